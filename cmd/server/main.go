@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -19,7 +21,9 @@ var (
 )
 
 const (
-	redirectURI = "http://127.0.0.1:8080/login-callback"
+	redirectURI        = "http://127.0.0.1:8080/login-callback"
+	defaultJWTIssuer   = "woahtify-backend"
+	defaultJWTAudience = "woahtify-client"
 )
 
 func main() {
@@ -42,14 +46,51 @@ func main() {
 		return
 	}
 
-	auth := spotifyauth.New(spotifyauth.WithRedirectURL(redirectURI), spotifyauth.WithClientID(SPOTIFY_ID), spotifyauth.WithClientSecret(SPOTIFY_SECRET), spotifyauth.WithScopes(spotifyauth.ScopeUserReadPrivate))
+	auth := spotifyauth.New(spotifyauth.WithRedirectURL(redirectURI), spotifyauth.WithClientID(SPOTIFY_ID), spotifyauth.WithClientSecret(SPOTIFY_SECRET), spotifyauth.WithScopes(
+		spotifyauth.ScopeUserReadPrivate,
+		spotifyauth.ScopeUserReadPlaybackState,
+		spotifyauth.ScopeUserModifyPlaybackState,
+		spotifyauth.ScopeUserReadCurrentlyPlaying,
+	))
+	memoryStore := api.NewMemoryStore()
+	var store api.Store = memoryStore
+	var broker api.Broker = api.NewMemoryBroker()
+	var pinger api.Pinger = memoryStore
+	if redis != nil {
+		store = redis
+		broker = redis
+		pinger = redis
+	} else {
+		log.Println("Redis unavailable, room state will not survive a restart or be shared between instances")
+	}
+
+	jwtKeys, err := loadJWTKeys()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
 	// Setup API handlers with dependencies
-	apiHandler := api.New(redis, auth)
+	apiHandler := api.New(pinger, auth, store, broker, jwtKeys)
+	if usePKCE, err := utils.GetEnv("SPOTIFY_USE_PKCE"); err == nil && usePKCE == "true" {
+		apiHandler.UsePKCE = true
+	}
+	heartbeat, err := loadHeartbeatConfig()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	if err := apiHandler.WSServer.SetHeartbeat(heartbeat); err != nil {
+		log.Fatal(err)
+		return
+	}
 	r := mux.NewRouter()
 	r.Handle("/health", api.CorsMiddleware(http.HandlerFunc(apiHandler.HealthHandler))).Methods("GET")
+	r.Handle("/metrics", api.CorsMiddleware(http.HandlerFunc(apiHandler.MetricsHandler))).Methods("GET")
 	r.Handle("/login", api.CorsMiddleware(http.HandlerFunc(apiHandler.LoginHandler))).Methods("GET")
-	r.Handle("/login-callback", api.CorsMiddleware(http.HandlerFunc(apiHandler.LoginHandler))).Methods("GET")
+	r.Handle("/login-callback", api.CorsMiddleware(http.HandlerFunc(apiHandler.SpotifyOAuthHandler))).Methods("GET")
 
+	r.Handle("/guest-token", api.CorsMiddleware(http.HandlerFunc(apiHandler.GuestTokenHandler))).Methods("POST", "OPTIONS")
 	r.Handle("/create-room", api.CorsMiddleware(apiHandler.AuthMiddleware(http.HandlerFunc(apiHandler.CreateRoomHandler)))).Methods("POST", "OPTIONS")
 
 	// Unprotected route
@@ -57,6 +98,14 @@ func main() {
 	r.Handle("/suggest-song", api.CorsMiddleware(http.HandlerFunc(apiHandler.SuggestSongHandler))).Methods("POST", "OPTIONS")
 	r.Handle("/vote-for-song", api.CorsMiddleware(http.HandlerFunc(apiHandler.VoteHandler))).Methods("POST", "OPTIONS")
 	r.Handle("/skip-song", api.CorsMiddleware(http.HandlerFunc(apiHandler.SkipSongHandler))).Methods("POST", "OPTIONS")
+	r.Handle("/vote-skip", api.CorsMiddleware(http.HandlerFunc(apiHandler.VoteSkipHandler))).Methods("POST", "OPTIONS")
+	r.Handle("/remove-song", api.CorsMiddleware(http.HandlerFunc(apiHandler.RemoveSongHandler))).Methods("POST", "OPTIONS")
+	r.Handle("/pin-song", api.CorsMiddleware(http.HandlerFunc(apiHandler.PinSongHandler))).Methods("POST", "OPTIONS")
+	r.Handle("/lock-queue", api.CorsMiddleware(http.HandlerFunc(apiHandler.LockQueueHandler))).Methods("POST", "OPTIONS")
+	r.Handle("/create-invite", api.CorsMiddleware(http.HandlerFunc(apiHandler.CreateInviteHandler))).Methods("POST", "OPTIONS")
+	r.Handle("/kick-user", api.CorsMiddleware(http.HandlerFunc(apiHandler.KickUserHandler))).Methods("POST", "OPTIONS")
+	r.Handle("/unban-user", api.CorsMiddleware(http.HandlerFunc(apiHandler.UnbanUserHandler))).Methods("POST", "OPTIONS")
+	r.Handle("/search", api.CorsMiddleware(http.HandlerFunc(apiHandler.SearchHandler))).Methods("GET", "OPTIONS")
 
 	port, err := utils.GetEnv("PORT")
 	if err != nil {
@@ -66,3 +115,52 @@ func main() {
 	log.Printf("Server running on port %s...", port)
 	log.Fatal(http.ListenAndServe("127.0.0.1:"+port, r))
 }
+
+// loadJWTKeys reads the session JWT configuration. JWT_KEYS lists every key that is
+// still accepted as "kid:secret" pairs and JWT_ACTIVE_KID names the one used for signing,
+// so a key can be rotated by adding a new one, switching JWT_ACTIVE_KID, and later removing the old one.
+func loadJWTKeys() (*api.JWTKeySet, error) {
+	keySpec, err := utils.GetEnv("JWT_KEYS")
+	if err != nil {
+		return nil, err
+	}
+	activeKID, err := utils.GetEnv("JWT_ACTIVE_KID")
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := utils.GetEnv("JWT_ISSUER")
+	if err != nil {
+		issuer = defaultJWTIssuer
+	}
+	audience, err := utils.GetEnv("JWT_AUDIENCE")
+	if err != nil {
+		audience = defaultJWTAudience
+	}
+
+	keys, err := api.ParseJWTKeys(keySpec)
+	if err != nil {
+		return nil, err
+	}
+	return api.NewJWTKeySet(keys, activeKID, issuer, audience, api.NewRealClock())
+}
+
+// loadHeartbeatConfig reads HEARTBEAT_PING_INTERVAL and HEARTBEAT_PONG_TIMEOUT, e.g. "25s",
+// falling back to the defaults for either one that isn't set.
+func loadHeartbeatConfig() (api.HeartbeatConfig, error) {
+	config := api.DefaultHeartbeatConfig()
+	if value, err := utils.GetEnv("HEARTBEAT_PING_INTERVAL"); err == nil {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return config, fmt.Errorf("invalid HEARTBEAT_PING_INTERVAL: %v", err)
+		}
+		config.PingInterval = interval
+	}
+	if value, err := utils.GetEnv("HEARTBEAT_PONG_TIMEOUT"); err == nil {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return config, fmt.Errorf("invalid HEARTBEAT_PONG_TIMEOUT: %v", err)
+		}
+		config.PongTimeout = timeout
+	}
+	return config, nil
+}
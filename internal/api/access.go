@@ -0,0 +1,186 @@
+package api
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Access modes decide who may join a room.
+const (
+	AccessModeOpen     = "open"
+	AccessModePassword = "password"
+	// AccessModeInvite only admits holders of an invite token signed for the room.
+	AccessModeInvite = "invite"
+)
+
+const (
+	defaultInviteTTL = 24 * time.Hour
+	maxInviteTTL     = 7 * 24 * time.Hour
+)
+
+// RoomOptions are the settings chosen when a room is created.
+type RoomOptions struct {
+	HostHandoff string
+	AccessMode  string
+	// Password is only used in AccessModePassword. It is hashed before it is stored.
+	Password string
+	// RemoveBelowScore defaults to defaultRemoveBelowScore when nil.
+	RemoveBelowScore *int
+	// SkipThreshold defaults to defaultSkipThreshold when zero.
+	SkipThreshold float64
+	// QueueStrategy defaults to QueueStrategyVotes.
+	QueueStrategy string
+	// SuggestionLimits defaults to DefaultSuggestionLimits when nil.
+	SuggestionLimits *SuggestionLimits
+}
+
+func (o *RoomOptions) validate() error {
+	if o.HostHandoff == "" {
+		o.HostHandoff = HostHandoffPromoteLongest
+	}
+	if !validHostHandoff(o.HostHandoff) {
+		return fmt.Errorf("invalid hostHandoff %s", o.HostHandoff)
+	}
+	switch o.AccessMode {
+	case "":
+		o.AccessMode = AccessModeOpen
+	case AccessModeOpen, AccessModeInvite:
+	case AccessModePassword:
+		if o.Password == "" {
+			return fmt.Errorf("a password is required for a password-protected room")
+		}
+	default:
+		return fmt.Errorf("invalid accessMode %s", o.AccessMode)
+	}
+	if o.RemoveBelowScore == nil {
+		removeBelowScore := defaultRemoveBelowScore
+		o.RemoveBelowScore = &removeBelowScore
+	}
+	if *o.RemoveBelowScore > 0 {
+		return fmt.Errorf("removeBelowScore can't be positive")
+	}
+	if o.SkipThreshold == 0 {
+		o.SkipThreshold = defaultSkipThreshold
+	}
+	if !validSkipThreshold(o.SkipThreshold) {
+		return fmt.Errorf("skipThreshold must be more than 0 and at most 1")
+	}
+	if o.QueueStrategy == "" {
+		o.QueueStrategy = QueueStrategyVotes
+	}
+	if !validQueueStrategy(o.QueueStrategy) {
+		return fmt.Errorf("invalid queueStrategy %s", o.QueueStrategy)
+	}
+	if o.SuggestionLimits == nil {
+		limits := DefaultSuggestionLimits
+		o.SuggestionLimits = &limits
+	}
+	if err := o.SuggestionLimits.validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// InviteClaims are the claims of an invite token. Invites are signed with a key derived
+// from the room's secret, so they only work for the room they were issued for.
+type InviteClaims struct {
+	RoomName string `json:"room"`
+	jwt.RegisteredClaims
+}
+
+func inviteKey(room *RoomConfig) []byte {
+	key := sha256.Sum256([]byte("invite:" + room.Secret))
+	return key[:]
+}
+
+// issueInvite signs an invite to room that expires after ttl.
+// It must be called with the mutex held.
+func (ws *WSServer) issueInvite(room *RoomConfig, ttl time.Duration) (string, time.Time, error) {
+	if ttl <= 0 {
+		ttl = defaultInviteTTL
+	}
+	if ttl > maxInviteTTL {
+		return "", time.Time{}, fmt.Errorf("invites can last at most %s", maxInviteTTL)
+	}
+	now := ws.clock.Now()
+	expiresAt := now.Add(ttl)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &InviteClaims{
+		RoomName: room.RoomName,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+	signed, err := token.SignedString(inviteKey(room))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// createInvite issues an invite on behalf of a user allowed to change the room's settings.
+func (ws *WSServer) createInvite(roomName, connectionID string, ttl time.Duration) (string, time.Time, error) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	ws.refreshRoom(roomName)
+	room, roomExists := ws.getRoom(roomName)
+	if !roomExists {
+		return "", time.Time{}, fmt.Errorf("room %s not present", roomName)
+	}
+	if _, err := ws.authorize(room, connectionID, PermissionChangeSettings); err != nil {
+		return "", time.Time{}, err
+	}
+	return ws.issueInvite(room, ttl)
+}
+
+// validInvite must be called with the mutex held.
+func (ws *WSServer) validInvite(room *RoomConfig, tokenString string) bool {
+	var claims InviteClaims
+	token, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+		return inviteKey(room), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(ws.clock.Now),
+	)
+	return err == nil && token.Valid && claims.RoomName == room.RoomName
+}
+
+// checkAccess reports whether a new joiner presenting password or invite may enter the room.
+// Password hashes are compared without holding the mutex, since bcrypt is deliberately slow.
+func (ws *WSServer) checkAccess(roomName, password, invite string) error {
+	ws.mutex.Lock()
+	room, roomExists := ws.getRoom(roomName)
+	if !roomExists {
+		ws.mutex.Unlock()
+		return fmt.Errorf("room '%s' not found", roomName)
+	}
+	accessMode, passwordHash := room.AccessMode, room.PasswordHash
+	inviteOK := accessMode == AccessModeInvite && ws.validInvite(room, invite)
+	ws.mutex.Unlock()
+
+	switch accessMode {
+	case AccessModePassword:
+		if password == "" || bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+			return fmt.Errorf("wrong password for room '%s'", roomName)
+		}
+	case AccessModeInvite:
+		if !inviteOK {
+			return fmt.Errorf("a valid invite is required to join room '%s'", roomName)
+		}
+	}
+	return nil
+}
+
+func hashRoomPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
@@ -0,0 +1,23 @@
+package api
+
+import "testing"
+
+func TestOwnerRejoinsInviteOnlyRoom(t *testing.T) {
+	a, srv := newTestAPI(t, NewRealClock())
+	if _, err := a.WSServer.addRoom("party", testHost("host"), RoomOptions{AccessMode: AccessModeInvite}); err != nil {
+		t.Fatal(err)
+	}
+
+	guest := joinRequest{RoomName: "party", Identity: Identity{UserID: guestIDPrefix + "guest", UserName: "guest"}}
+	if _, err := a.WSServer.joinUser(guest, nil); err == nil {
+		t.Error("guest joined an invite-only room without an invite")
+	}
+
+	// The owner holds no invite, but still gets back in.
+	joinTestRoom(t, a, srv, "party", "host")
+	a.WSServer.mutex.Lock()
+	defer a.WSServer.mutex.Unlock()
+	if room := a.WSServer.roomConfigMap["party"]; !room.IsHostPresent {
+		t.Error("owner didn't rejoin as host")
+	}
+}
@@ -7,8 +7,6 @@ import (
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
 )
 
-const jwtSecret = "dummy-secret"
-
 // Pinger defines the interface for services that can be pinged for a health check.
 type Pinger interface {
 	Ping() (string, error)
@@ -18,70 +16,64 @@ type Pinger interface {
 type API struct {
 	Redis                Pinger
 	WSServer             *WSServer
-	AccessTokenMap       map[string]SpotifyTokenInfo
+	Tokens               *TokenManager
+	Sessions             *SessionStore
+	JWTKeys              *JWTKeySet
+	LoginStates          *LoginStateStore
 	SpotifyAuthenticator *spotifyauth.Authenticator
-}
-
-type SpotifyTokenInfo struct {
-	UserName     string
-	AccessToken  string
-	RefreshToken string
-	Expiry       int64
+	// SpotifyBaseURL overrides the Spotify Web API endpoint, e.g. to use a fake server in tests.
+	SpotifyBaseURL string
+	// UsePKCE adds a PKCE code challenge to every Spotify login.
+	UsePKCE bool
 }
 
 // New creates a new API handler with its dependencies.
-func New(redis Pinger, spotifyAuthenticator *spotifyauth.Authenticator) *API {
-	return &API{
-		Redis:    redis,
-		WSServer: NewWSServer(),
+// store backs the persisted room state and broker relays room updates between instances;
+// pass a MemoryStore and MemoryBroker when Redis isn't available.
+// jwtKeys signs and validates the session JWTs handed to clients.
+func New(redis Pinger, spotifyAuthenticator *spotifyauth.Authenticator, store Store, broker Broker, jwtKeys *JWTKeySet) *API {
+	a := &API{
+		Redis:                redis,
+		Tokens:               NewTokenManager(spotifyAuthenticator, store),
+		Sessions:             NewSessionStore(store),
+		JWTKeys:              jwtKeys,
+		LoginStates:          NewLoginStateStore(store),
 		SpotifyAuthenticator: spotifyAuthenticator,
 	}
+	a.WSServer = NewWSServer(NewRoomStore(store), broker, &spotifyPlayer{api: a}, a, NewRealClock())
+	return a
 }
 
-func (s SpotifyTokenInfo) GenerateJWTToken() (string, error) {
-	claims := jwt.MapClaims{
-		"userName":     s.UserName,
-		"accessToken":  s.AccessToken,
-		"refreshToken": s.RefreshToken,
-		"expiry":       s.Expiry,
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+// SessionClaims are the claims of a session JWT. They only carry the opaque
+// session ID, never Spotify credentials; the subject is the Spotify user ID.
+type SessionClaims struct {
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
 }
 
-func ValidateJWT(tokenString string) (*SpotifyTokenInfo, error) {
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
+// GenerateJWTToken issues the session JWT handed to the browser.
+func (a *API) GenerateJWTToken(session *Session) (string, error) {
+	return a.JWTKeys.Sign(&SessionClaims{
+		SessionID:        session.ID,
+		RegisteredClaims: a.JWTKeys.registeredClaims(session.UserID, session.ExpiresAt),
 	})
-	if err != nil || !token.Valid {
-		return nil, fmt.Errorf("invalid token")
-	}
+}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, fmt.Errorf("invalid claims")
-	}
-	userName, ok := claims["userName"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid userName in JWT token")
+// ValidateJWT checks the session JWT and resolves it to the server-side session.
+func (a *API) ValidateJWT(tokenString string) (*Session, error) {
+	var claims SessionClaims
+	if err := a.JWTKeys.Parse(tokenString, &claims); err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
 	}
-	accessToken, ok := claims["accessToken"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid authToken in JWT token")
+	if claims.SessionID == "" {
+		return nil, fmt.Errorf("invalid sid in JWT token")
 	}
-	refreshToken, ok := claims["refreshToken"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid authToken in JWT token")
+	session, err := a.Sessions.Get(claims.SessionID)
+	if err != nil {
+		return nil, err
 	}
-	expiry, ok := claims["expiry"].(int64)
-	if !ok {
-		return nil, fmt.Errorf("invalid authToken in JWT token")
+	if session.UserID != claims.Subject {
+		return nil, fmt.Errorf("session doesn't belong to token subject")
 	}
-	return &SpotifyTokenInfo{
-		UserName:     userName,
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		Expiry:       expiry,
-	}, nil
+	return session, nil
 }
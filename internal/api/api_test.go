@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+)
+
+// newTestAPI returns an API backed by in-memory state whose rooms run on clock, and a
+// server for its join endpoint.
+func newTestAPI(t *testing.T, clock Clock) (*API, *httptest.Server) {
+	t.Helper()
+	return newTestInstance(t, clock, NewMemoryStore(), NewMemoryBroker())
+}
+
+// newTestInstance is newTestAPI for one of several instances sharing store and broker.
+func newTestInstance(t *testing.T, clock Clock, store Store, broker Broker) (*API, *httptest.Server) {
+	t.Helper()
+	keys, err := NewJWTKeySet(map[string][]byte{"test": []byte("0123456789abcdef0123456789abcdef")}, "test", "test-issuer", "test-audience", NewRealClock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := spotifyauth.New()
+	a := &API{
+		Tokens:               NewTokenManager(auth, store),
+		Sessions:             NewSessionStore(store),
+		JWTKeys:              keys,
+		LoginStates:          NewLoginStateStore(store),
+		SpotifyAuthenticator: auth,
+	}
+	a.WSServer = NewWSServer(NewRoomStore(store), broker, nil, a, clock)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/join-room", a.JoinRoomHandler)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return a, srv
+}
+
+// joinTestRoom connects nickname to roomName with a guest token and returns the
+// connection along with the connection ID from its snapshot. The guest ID is
+// derived from the nickname, so testHost(nickname) joins as the host.
+func joinTestRoom(t *testing.T, a *API, srv *httptest.Server, roomName, nickname string) (*websocket.Conn, string) {
+	t.Helper()
+	conn, snapshot := joinTestRoomWith(t, a, srv, roomName, nickname, nil)
+	return conn, snapshot.ConnectionID
+}
+
+// joinTestRoomWith is joinTestRoom with extra query parameters, returning the whole snapshot.
+func joinTestRoomWith(t *testing.T, a *API, srv *httptest.Server, roomName, nickname string, extra url.Values) (*websocket.Conn, BroadcastMessage) {
+	t.Helper()
+	token, err := a.JWTKeys.Sign(&GuestClaims{
+		Nickname:         nickname,
+		RegisteredClaims: a.JWTKeys.registeredClaims(guestIDPrefix+nickname, time.Now().Add(time.Hour)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := url.Values{"roomName": {roomName}, "token": {token}}
+	for key, values := range extra {
+		query[key] = values
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/join-room?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatalf("could not join %s as %s: %v", roomName, nickname, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	// The joiner may hear its own user_joined event before the snapshot.
+	var snapshot BroadcastMessage
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for snapshot.Type != FrameState {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("no snapshot for %s: %v", nickname, err)
+		}
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+	return conn, snapshot
+}
+
+// testHost is the host of rooms created in tests. It joins with a guest token for its name.
+func testHost(name string) WSUser {
+	return WSUser{UserName: name, UserType: UserTypeHost, UserID: guestIDPrefix + name}
+}
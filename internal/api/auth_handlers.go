@@ -1,18 +1,10 @@
 package api
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"time"
-
-	"github.com/zmb3/spotify/v2"
-)
-
-var (
-	state = "abc123"
 )
 
 func (a *API) LoginHandler(w http.ResponseWriter, r *http.Request) {
@@ -25,44 +17,65 @@ func (a *API) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	url := a.SpotifyAuthenticator.AuthURL(state)
+	state, opts, err := a.LoginStates.Begin(a.UsePKCE)
+	if err != nil {
+		log.Printf("Could not start login: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Couldn't start login"})
+		return
+	}
+
+	url := a.SpotifyAuthenticator.AuthURL(state, opts...)
 	log.Println("Redirecting user to:", url)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(LoginResponse{RedirectURL: url})
 }
 
+// SpotifyOAuthHandler completes a login started by LoginHandler. A bad or replayed
+// callback is answered with an error and never affects other users.
 func (a *API) SpotifyOAuthHandler(w http.ResponseWriter, r *http.Request) {
-	tok, err := a.SpotifyAuthenticator.Token(r.Context(), state, r)
+	state := r.FormValue("state")
+	opts, err := a.LoginStates.Redeem(state)
 	if err != nil {
-		http.Error(w, "Couldn't get token", http.StatusForbidden)
-		log.Fatal(err)
+		log.Printf("Rejected login callback: %v", err)
+		writeCallbackError(w, http.StatusBadRequest, "Invalid or expired login, please try again")
+		return
 	}
-	if st := r.FormValue("state"); st != state {
-		http.NotFound(w, r)
-		log.Fatalf("State mismatch: %s != %s\n", st, state)
+
+	tok, err := a.SpotifyAuthenticator.Token(r.Context(), state, r, opts...)
+	if err != nil {
+		log.Printf("Could not get Spotify token: %v", err)
+		writeCallbackError(w, http.StatusForbidden, "Couldn't get token")
+		return
 	}
 
-	client := spotify.New(a.SpotifyAuthenticator.Client(r.Context(), tok))
-	user, err := client.CurrentUser(context.Background())
+	client := a.newSpotifyClient(r.Context(), tok)
+	user, err := client.CurrentUser(r.Context())
 	if err != nil {
-		http.Error(w, "Couldn't get user info", http.StatusForbidden)
-		log.Fatal(err)
+		log.Printf("Could not get Spotify user info: %v", err)
+		writeCallbackError(w, http.StatusBadGateway, "Couldn't get user info")
+		return
 	}
 
-	userInfo := SpotifyTokenInfo{
-		AccessToken:  tok.AccessToken,
-		RefreshToken: tok.RefreshToken,
-		UserName:     user.DisplayName,
-		Expiry:       time.Now().Add(24 * time.Hour).Unix(),
+	if err := a.Tokens.Save(user.ID, tok); err != nil {
+		log.Printf("Could not save Spotify token for %s: %v", user.DisplayName, err)
+		writeCallbackError(w, http.StatusInternalServerError, "Couldn't save Spotify token")
+		return
 	}
 
-	a.AccessTokenMap[userInfo.UserName] = userInfo
+	session, err := a.Sessions.Create(user.ID, user.DisplayName)
+	if err != nil {
+		log.Printf("Could not create session for %s: %v", user.DisplayName, err)
+		writeCallbackError(w, http.StatusInternalServerError, "Couldn't create session")
+		return
+	}
 
-	jwt, err := userInfo.GenerateJWTToken()
+	jwt, err := a.GenerateJWTToken(session)
 	if err != nil {
-		http.Error(w, "Couldn't generate JWT", http.StatusInternalServerError)
-		log.Fatal(err)
+		log.Printf("Could not generate JWT for %s: %v", user.DisplayName, err)
+		writeCallbackError(w, http.StatusInternalServerError, "Couldn't generate JWT")
+		return
 	}
 
 	origin := getOrigin(r)
@@ -71,6 +84,35 @@ func (a *API) SpotifyOAuthHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, redirectURL, http.StatusFound)
 }
 
+// GuestTokenHandler issues a guest token, the identity people who don't sign in join rooms with.
+func (a *API) GuestTokenHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req GuestTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request payload"})
+		return
+	}
+
+	token, identity, expiresAt, err := a.GenerateGuestToken(req.Nickname)
+	if err != nil {
+		log.Printf("Could not issue guest token: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(GuestTokenResponse{Token: token, UserName: identity.UserName, ExpiresAt: expiresAt})
+}
+
+func writeCallbackError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message})
+}
+
 func getOrigin(r *http.Request) string {
 	scheme := "http"
 	if r.TLS != nil {
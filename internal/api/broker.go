@@ -0,0 +1,63 @@
+package api
+
+import (
+	"log"
+	"sync"
+)
+
+// Broker fans room updates out to every server instance.
+// It is satisfied by *redis_client.Redis and by the in-process MemoryBroker.
+type Broker interface {
+	Publish(channel string, message []byte) error
+	Subscribe(channel string) (<-chan []byte, func())
+}
+
+// MemoryBroker delivers messages to subscribers within the same process.
+// It is enough for a single instance and for tests.
+type MemoryBroker struct {
+	subscribers map[string]map[chan []byte]struct{}
+	mutex       *sync.Mutex
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		subscribers: make(map[string]map[chan []byte]struct{}),
+		mutex:       &sync.Mutex{},
+	}
+}
+
+func (b *MemoryBroker) Publish(channel string, message []byte) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for sub := range b.subscribers[channel] {
+		select {
+		case sub <- message:
+		default:
+			log.Printf("Warning: subscriber on %s is full. Message dropped.", channel)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(channel string) (<-chan []byte, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	sub := make(chan []byte, 16)
+	if _, exists := b.subscribers[channel]; !exists {
+		b.subscribers[channel] = make(map[chan []byte]struct{})
+	}
+	b.subscribers[channel][sub] = struct{}{}
+
+	var once sync.Once
+	return sub, func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			delete(b.subscribers[channel], sub)
+			if len(b.subscribers[channel]) == 0 {
+				delete(b.subscribers, channel)
+			}
+			close(sub)
+		})
+	}
+}
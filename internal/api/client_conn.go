@@ -0,0 +1,97 @@
+package api
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// clientSendBuffer is how many frames may wait for a client before it counts as a slow consumer.
+	clientSendBuffer = 64
+	// writeWait bounds how long a single frame may take to write.
+	writeWait = 10 * time.Second
+)
+
+// clientConn is the server's per-connection state. Frames are written by the
+// connection's own writePump, so a stalled client only ever holds up itself.
+type clientConn struct {
+	send      chan []byte
+	lastSeen  time.Time
+	pingTimer Timer
+}
+
+// trackConn starts the write pump and heartbeat of a newly joined connection.
+// It must be called with the mutex held.
+func (ws *WSServer) trackConn(conn *websocket.Conn) {
+	client := &clientConn{
+		send:     make(chan []byte, clientSendBuffer),
+		lastSeen: ws.clock.Now(),
+	}
+	ws.conns[conn] = client
+	go ws.writePump(conn, client.send)
+	ws.schedulePing(conn, client)
+}
+
+// untrackConn stops the connection's heartbeat and write pump and forgets it.
+// It must be called with the mutex held.
+func (ws *WSServer) untrackConn(conn *websocket.Conn) {
+	client, exists := ws.conns[conn]
+	if !exists {
+		return
+	}
+	if client.pingTimer != nil {
+		client.pingTimer.Stop()
+	}
+	close(client.send)
+	delete(ws.conns, conn)
+}
+
+// writeTo queues a text frame for a single connection. A client whose queue is full
+// has fallen too far behind and is disconnected rather than allowed to hold up the room.
+func (ws *WSServer) writeTo(conn *websocket.Conn, data []byte) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	client, exists := ws.conns[conn]
+	if !exists {
+		return
+	}
+
+	select {
+	case client.send <- data:
+	default:
+		ws.metrics.slowConsumerDrops.Add(1)
+		ws.evictSlowConsumer(conn)
+	}
+}
+
+// evictSlowConsumer must be called with the mutex held.
+// Closing the connection ends its read loop, which removes the user the usual way.
+func (ws *WSServer) evictSlowConsumer(conn *websocket.Conn) {
+	log.Printf("Client %s is too slow to keep up, disconnecting it", conn.RemoteAddr())
+	ws.metrics.slowConsumerEvictions.Add(1)
+	ws.untrackConn(conn)
+	// Control frames may be written concurrently with the write pump.
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "client too slow")
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(controlWriteWait))
+	conn.Close()
+}
+
+// writePump writes the connection's queued frames until the queue is closed.
+func (ws *WSServer) writePump(conn *websocket.Conn, send <-chan []byte) {
+	for data := range send {
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			log.Printf("Error writing to client: %v\n", err)
+			ws.metrics.writeErrorDrops.Add(1)
+			// Give up on the connection; its read loop will notice and clean up.
+			conn.Close()
+			for range send {
+				ws.metrics.writeErrorDrops.Add(1)
+			}
+			return
+		}
+		ws.metrics.framesSent.Add(1)
+	}
+}
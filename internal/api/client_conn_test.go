@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// serverConn returns the server side of userName's connection to room party.
+func serverConn(ws *WSServer, userName string) *websocket.Conn {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	for conn, user := range ws.roomConfigMap["party"].Clients {
+		if user.UserName == userName {
+			return conn
+		}
+	}
+	return nil
+}
+
+func TestWritePumpKeepsFrameOrder(t *testing.T) {
+	a, srv := newTestAPI(t, NewRealClock())
+	ws := a.WSServer
+	if _, err := ws.addRoom("party", testHost("host"), RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	joinTestRoom(t, a, srv, "party", "host")
+	guest, _ := joinTestRoom(t, a, srv, "party", "guest")
+
+	conn := serverConn(ws, "guest")
+	for i := 0; i < clientSendBuffer; i++ {
+		ws.writeTo(conn, []byte(fmt.Sprintf(`{"frame":%d}`, i)))
+	}
+	guest.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for want := 0; want < clientSendBuffer; {
+		_, data, err := guest.ReadMessage()
+		if err != nil {
+			t.Fatalf("read %d frames, then: %v", want, err)
+		}
+		// Room events may be interleaved with the test frames.
+		if !strings.HasPrefix(string(data), `{"frame":`) {
+			continue
+		}
+		if got := string(data); got != fmt.Sprintf(`{"frame":%d}`, want) {
+			t.Fatalf("got %s, want frame %d", got, want)
+		}
+		want++
+	}
+}
+
+func TestSlowConsumerIsEvicted(t *testing.T) {
+	a, srv := newTestAPI(t, NewRealClock())
+	ws := a.WSServer
+	if _, err := ws.addRoom("party", testHost("host"), RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	joinTestRoom(t, a, srv, "party", "host")
+	guest, _ := joinTestRoom(t, a, srv, "party", "guest")
+
+	// Swap in a queue nothing drains, standing in for a client that stopped reading.
+	conn := serverConn(ws, "guest")
+	ws.mutex.Lock()
+	ws.untrackConn(conn)
+	ws.conns[conn] = &clientConn{send: make(chan []byte, 1)}
+	ws.mutex.Unlock()
+	ws.writeTo(conn, []byte(`{"frame":0}`))
+	ws.writeTo(conn, []byte(`{"frame":1}`))
+
+	guest.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := guest.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+			t.Fatalf("connection ended with %v, want a policy violation close", err)
+		}
+		break
+	}
+	if got := ws.metrics.slowConsumerEvictions.Load(); got != 1 {
+		t.Errorf("%d slow consumers evicted, want 1", got)
+	}
+}
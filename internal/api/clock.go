@@ -0,0 +1,29 @@
+package api
+
+import "time"
+
+// Clock abstracts time so timers can be driven by a fake clock in tests.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is the part of *time.Timer that the server relies on.
+type Timer interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+// NewRealClock returns a Clock backed by the time package.
+func NewRealClock() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
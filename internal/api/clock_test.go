@@ -0,0 +1,72 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when a test advances it.
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	timer := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	active := !t.stopped
+	t.stopped = true
+	return active
+}
+
+// Advance moves the clock forward by d, running the timers that come due in the order
+// they are due, with the clock set to when each was due. Timers run on the caller's
+// goroutine, and timers they arm run too if they come due within d.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	end := c.now.Add(d)
+	for {
+		var next *fakeTimer
+		for _, timer := range c.timers {
+			if !timer.stopped && !timer.at.After(end) && (next == nil || timer.at.Before(next.at)) {
+				next = timer
+			}
+		}
+		if next == nil {
+			break
+		}
+		next.stopped = true
+		c.now = next.at
+		c.mutex.Unlock()
+		next.f()
+		c.mutex.Lock()
+	}
+	c.now = end
+	c.mutex.Unlock()
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolVersion is the version of the WebSocket envelope understood by this server.
+const ProtocolVersion = 1
+
+const commandTimeout = 10 * time.Second
+
+// Command types sent by clients.
+const (
+	CommandSuggest = "suggest"
+	CommandVote    = "vote"
+	CommandSkip    = "skip"
+	CommandChat    = "chat"
+	CommandSetRole = "set_role"
+	CommandMute    = "mute"
+	CommandKick    = "kick"
+	CommandUnban   = "unban"
+
+	// CommandVoteSkip votes to skip the current song, where skip skips it outright.
+	CommandVoteSkip = "vote_skip"
+
+	// Queue management for hosts and moderators.
+	CommandRemoveSong = "remove_song"
+	CommandPinSong    = "pin_song"
+	CommandLockQueue  = "lock_queue"
+)
+
+// Frame types sent by the server.
+const (
+	FrameAck   = "ack"
+	FrameError = "error"
+	FrameState = "state"
+	FrameChat  = "chat"
+)
+
+// Error codes carried by error frames.
+const (
+	ErrorCodeBadRequest         = "bad_request"
+	ErrorCodeUnknownCommand     = "unknown_command"
+	ErrorCodeUnsupportedVersion = "unsupported_version"
+	ErrorCodeRejected           = "rejected"
+	ErrorCodeForbidden          = "forbidden"
+)
+
+// Envelope wraps every command a client sends over the socket and every ack or error
+// frame the server sends back. RequestID is chosen by the client and echoed in the reply.
+type Envelope struct {
+	Version   int             `json:"v"`
+	Type      string          `json:"type"`
+	RequestID string          `json:"requestId,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// SongCommand is the payload of suggest, vote, skip, vote_skip and remove_song commands.
+// Like the REST requests, TrackURI is preferred and SongName is the name-only fallback.
+// Direction is only read by vote, see VoteRequest.
+type SongCommand struct {
+	TrackURI  string `json:"trackUri"`
+	SongName  string `json:"songName"`
+	Direction string `json:"direction,omitempty"`
+}
+
+type ChatCommand struct {
+	Message string `json:"message"`
+}
+
+// RoleCommand grants Role to UserName; granting "guest" revokes their role.
+type RoleCommand struct {
+	UserName string `json:"userName"`
+	Role     string `json:"role"`
+}
+
+// UserCommand is the payload of commands about another user in the room.
+type UserCommand struct {
+	UserName string `json:"userName"`
+}
+
+// KickCommand removes UserName from the room; with Ban set they can't rejoin.
+type KickCommand struct {
+	UserName string `json:"userName"`
+	Reason   string `json:"reason"`
+	Ban      bool   `json:"ban"`
+}
+
+// PinCommand pins a song to play next, or with Pinned unset unpins it.
+type PinCommand struct {
+	TrackURI string `json:"trackUri"`
+	SongName string `json:"songName"`
+	Pinned   bool   `json:"pinned"`
+}
+
+// LockCommand locks the queue to Order, a list of song IDs, for DurationSeconds.
+// An empty Order unlocks it.
+type LockCommand struct {
+	Order           []string `json:"order"`
+	DurationSeconds int      `json:"durationSeconds"`
+}
+
+type MuteCommand struct {
+	UserName string `json:"userName"`
+	Muted    bool   `json:"muted"`
+}
+
+// CommandError is the payload of an error frame.
+type CommandError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RetryAfter is how many seconds to wait before trying again, when waiting will help.
+	RetryAfter int `json:"retryAfter,omitempty"`
+}
+
+func (e *CommandError) Error() string {
+	return e.Message
+}
+
+// errInvalidSuggestion marks suggestions that are malformed rather than rejected by the room.
+var errInvalidSuggestion = errors.New("invalid suggestion")
+
+// TrackResolver resolves Spotify track URIs to their metadata with a room's Spotify account.
+type TrackResolver interface {
+	LookupTrack(ctx context.Context, host WSUser, trackURI string) (Track, error)
+}
+
+// resolveSuggestion turns a suggestion into a Track, looking up its metadata when a URI is given.
+func (ws *WSServer) resolveSuggestion(ctx context.Context, roomName, connectionID, trackURI, songName string) (Track, error) {
+	if trackURI == "" {
+		if songName == "" {
+			return Track{}, fmt.Errorf("%w: either trackUri or songName is required", errInvalidSuggestion)
+		}
+		return Track{Title: songName}, nil
+	}
+
+	account, err := ws.roomAccountForConnection(roomName, connectionID)
+	if err != nil {
+		return Track{}, err
+	}
+	if ws.tracks == nil {
+		return Track{}, fmt.Errorf("%w: track lookup is not available", errInvalidSuggestion)
+	}
+	track, err := ws.tracks.LookupTrack(ctx, account, trackURI)
+	if err != nil {
+		log.Printf("Could not look up track %s: %v", trackURI, err)
+		return Track{}, fmt.Errorf("%w: could not find track %s", errInvalidSuggestion, trackURI)
+	}
+	return track, nil
+}
+
+// handleCommand runs a client command and replies on the client's connection with an ack or error frame.
+func (ws *WSServer) handleCommand(roomName, connectionID string, conn *websocket.Conn, envelope Envelope) {
+	err := ws.runCommand(roomName, connectionID, conn, envelope)
+	if err == nil {
+		ws.sendFrame(conn, Envelope{Version: ProtocolVersion, Type: FrameAck, RequestID: envelope.RequestID})
+		return
+	}
+
+	var commandErr *CommandError
+	if !errors.As(err, &commandErr) {
+		code := ErrorCodeRejected
+		var permissionErr *PermissionError
+		var quotaErr *QuotaError
+		retryAfter := 0
+		if errors.Is(err, errInvalidSuggestion) {
+			code = ErrorCodeBadRequest
+		} else if errors.As(err, &permissionErr) {
+			code = ErrorCodeForbidden
+		} else if errors.As(err, &quotaErr) {
+			code = quotaErr.Code
+			retryAfter = quotaErr.RetryAfterSeconds()
+		}
+		commandErr = &CommandError{Code: code, Message: err.Error(), RetryAfter: retryAfter}
+	}
+	payload, marshalErr := json.Marshal(commandErr)
+	if marshalErr != nil {
+		log.Printf("Error marshaling command error: %v\n", marshalErr)
+		return
+	}
+	ws.sendFrame(conn, Envelope{Version: ProtocolVersion, Type: FrameError, RequestID: envelope.RequestID, Payload: payload})
+}
+
+func (ws *WSServer) runCommand(roomName, connectionID string, conn *websocket.Conn, envelope Envelope) error {
+	if envelope.Version != ProtocolVersion {
+		return &CommandError{Code: ErrorCodeUnsupportedVersion, Message: fmt.Sprintf("unsupported protocol version %d", envelope.Version)}
+	}
+
+	switch envelope.Type {
+	case CommandSuggest, CommandVote, CommandSkip, CommandVoteSkip, CommandRemoveSong:
+		var cmd SongCommand
+		if err := json.Unmarshal(envelope.Payload, &cmd); err != nil {
+			return &CommandError{Code: ErrorCodeBadRequest, Message: "invalid payload"}
+		}
+		songID := songKey(cmd.TrackURI, cmd.SongName)
+		switch envelope.Type {
+		case CommandSuggest:
+			ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+			defer cancel()
+			track, err := ws.resolveSuggestion(ctx, roomName, connectionID, cmd.TrackURI, cmd.SongName)
+			if err != nil {
+				return err
+			}
+			return ws.addSuggestedSong(track, roomName, connectionID)
+		case CommandVote:
+			if cmd.Direction == "" {
+				cmd.Direction = VoteUp
+			}
+			if !validVoteDirection(cmd.Direction) {
+				return &CommandError{Code: ErrorCodeBadRequest, Message: "invalid direction"}
+			}
+			return ws.voteForSong(songID, roomName, connectionID, cmd.Direction)
+		case CommandVoteSkip:
+			return ws.voteToSkip(songID, roomName, connectionID)
+		case CommandRemoveSong:
+			return ws.removeSong(songID, roomName, connectionID)
+		default:
+			return ws.skipSong(songID, roomName, connectionID)
+		}
+	case CommandPinSong:
+		var cmd PinCommand
+		if err := json.Unmarshal(envelope.Payload, &cmd); err != nil || (cmd.TrackURI == "" && cmd.SongName == "") {
+			return &CommandError{Code: ErrorCodeBadRequest, Message: "invalid payload"}
+		}
+		return ws.pinSong(songKey(cmd.TrackURI, cmd.SongName), roomName, connectionID, cmd.Pinned)
+	case CommandLockQueue:
+		var cmd LockCommand
+		if err := json.Unmarshal(envelope.Payload, &cmd); err != nil || cmd.DurationSeconds < 0 {
+			return &CommandError{Code: ErrorCodeBadRequest, Message: "invalid payload"}
+		}
+		return ws.lockQueue(roomName, connectionID, cmd.Order, time.Duration(cmd.DurationSeconds)*time.Second)
+	case CommandChat:
+		var cmd ChatCommand
+		if err := json.Unmarshal(envelope.Payload, &cmd); err != nil || cmd.Message == "" {
+			return &CommandError{Code: ErrorCodeBadRequest, Message: "invalid payload"}
+		}
+		return ws.sendChat(roomName, conn, cmd.Message)
+	case CommandSetRole:
+		var cmd RoleCommand
+		if err := json.Unmarshal(envelope.Payload, &cmd); err != nil || cmd.UserName == "" || cmd.Role == "" {
+			return &CommandError{Code: ErrorCodeBadRequest, Message: "invalid payload"}
+		}
+		return ws.setRole(roomName, connectionID, cmd.UserName, cmd.Role)
+	case CommandMute:
+		var cmd MuteCommand
+		if err := json.Unmarshal(envelope.Payload, &cmd); err != nil || cmd.UserName == "" {
+			return &CommandError{Code: ErrorCodeBadRequest, Message: "invalid payload"}
+		}
+		return ws.setMuted(roomName, connectionID, cmd.UserName, cmd.Muted)
+	case CommandKick:
+		var cmd KickCommand
+		if err := json.Unmarshal(envelope.Payload, &cmd); err != nil || cmd.UserName == "" {
+			return &CommandError{Code: ErrorCodeBadRequest, Message: "invalid payload"}
+		}
+		return ws.kickUser(roomName, connectionID, cmd.UserName, cmd.Reason, cmd.Ban)
+	case CommandUnban:
+		var cmd UserCommand
+		if err := json.Unmarshal(envelope.Payload, &cmd); err != nil || cmd.UserName == "" {
+			return &CommandError{Code: ErrorCodeBadRequest, Message: "invalid payload"}
+		}
+		return ws.unbanUser(roomName, connectionID, cmd.UserName)
+	case CommandSnapshot:
+		ws.sendSnapshot(roomName, conn, nil)
+		return nil
+	default:
+		return &CommandError{Code: ErrorCodeUnknownCommand, Message: fmt.Sprintf("unknown command %s", envelope.Type)}
+	}
+}
+
+// sendChat broadcasts a chat message from the user on conn.
+func (ws *WSServer) sendChat(roomName string, conn *websocket.Conn, message string) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	room, roomExists := ws.roomConfigMap[roomName]
+	if !roomExists {
+		return fmt.Errorf("room %s not present", roomName)
+	}
+	sender, exists := room.Clients[conn]
+	if !exists {
+		return fmt.Errorf("user not present")
+	}
+	if err := checkPermission(sender, PermissionChat); err != nil {
+		return err
+	}
+	if sender.IsMuted {
+		return fmt.Errorf("you have been muted")
+	}
+	ws.broadcast(roomName, BroadcastMessage{
+		Type:     FrameChat,
+		RoomName: roomName,
+		Sender:   sender,
+		Message:  message,
+	})
+	return nil
+}
+
+// sendFrame writes a frame to a single connection.
+func (ws *WSServer) sendFrame(conn *websocket.Conn, envelope Envelope) {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		log.Printf("Error marshaling frame: %v\n", err)
+		return
+	}
+	ws.writeTo(conn, data)
+}
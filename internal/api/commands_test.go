@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sendTestCommand sends command on conn and returns the ack or error frame answering it.
+func sendTestCommand(t *testing.T, conn *websocket.Conn, command Envelope) Envelope {
+	t.Helper()
+	if err := conn.WriteJSON(command); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		var reply Envelope
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("no reply to %s: %v", command.RequestID, err)
+		}
+		// Room events and chat arrive on the same connection.
+		if (reply.Type == FrameAck || reply.Type == FrameError) && reply.RequestID == command.RequestID {
+			return reply
+		}
+	}
+}
+
+func TestCommandReplies(t *testing.T) {
+	a, srv := newTestAPI(t, NewRealClock())
+	if _, err := a.WSServer.addRoom("party", testHost("host"), RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	host, _ := joinTestRoom(t, a, srv, "party", "host")
+	guest, _ := joinTestRoom(t, a, srv, "party", "guest")
+
+	tests := []struct {
+		name     string
+		conn     *websocket.Conn
+		version  int
+		command  string
+		payload  string
+		wantCode string
+	}{
+		{name: "suggest", conn: host, command: CommandSuggest, payload: `{"songName":"first"}`},
+		{name: "chat", conn: guest, command: CommandChat, payload: `{"message":"hi"}`},
+		{name: "old version", conn: guest, version: 2, command: CommandChat, payload: `{"message":"hi"}`, wantCode: ErrorCodeUnsupportedVersion},
+		{name: "unknown command", conn: guest, command: "dance", wantCode: ErrorCodeUnknownCommand},
+		{name: "empty suggestion", conn: guest, command: CommandSuggest, payload: `{}`, wantCode: ErrorCodeBadRequest},
+		{name: "bad direction", conn: guest, command: CommandVote, payload: `{"songName":"first","direction":"sideways"}`, wantCode: ErrorCodeBadRequest},
+		{name: "guest skipping", conn: guest, command: CommandSkip, payload: `{"songName":"first"}`, wantCode: ErrorCodeForbidden},
+		{name: "guest suggests", conn: guest, command: CommandSuggest, payload: `{"songName":"second"}`},
+		{name: "cooldown", conn: guest, command: CommandSuggest, payload: `{"songName":"third"}`, wantCode: QuotaCooldown},
+		{name: "rejected", conn: guest, command: CommandVote, payload: `{"songName":"nothing"}`, wantCode: ErrorCodeRejected},
+	}
+	for _, test := range tests {
+		version := test.version
+		if version == 0 {
+			version = ProtocolVersion
+		}
+		reply := sendTestCommand(t, test.conn, Envelope{Version: version, Type: test.command, RequestID: test.name, Payload: json.RawMessage(test.payload)})
+		if test.wantCode == "" {
+			if reply.Type != FrameAck {
+				t.Errorf("%s: got %s %s, want an ack", test.name, reply.Type, reply.Payload)
+			}
+			continue
+		}
+		var commandErr CommandError
+		if reply.Type != FrameError || json.Unmarshal(reply.Payload, &commandErr) != nil || commandErr.Code != test.wantCode {
+			t.Errorf("%s: got %s %s, want a %s error", test.name, reply.Type, reply.Payload, test.wantCode)
+		}
+		if test.wantCode == QuotaCooldown && commandErr.RetryAfter <= 0 {
+			t.Errorf("%s: cooldown error without retryAfter", test.name)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// FrameEvent marks a delta update; FrameState frames carry a full snapshot.
+const FrameEvent = "event"
+
+// CommandSnapshot asks the server for a full state frame, e.g. after a client notices a gap in Seq.
+const CommandSnapshot = "snapshot"
+
+const (
+	EventSongAdded     = "song_added"
+	EventVoteChanged   = "vote_changed"
+	EventNowPlaying    = "now_playing"
+	EventUserJoined    = "user_joined"
+	EventUserLeft      = "user_left"
+	EventPlaybackError = "playback_error"
+)
+
+// RoomEvent is a delta update to a room. Seq grows by exactly one with every event,
+// and snapshots carry the Seq they are current up to, so a client can drop events it
+// already has and ask for a snapshot when it sees a gap.
+type RoomEvent struct {
+	Type     string `json:"type"`
+	Event    string `json:"event"`
+	RoomName string `json:"roomName"`
+	Seq      uint64 `json:"seq"`
+	Sender   WSUser `json:"sender"`
+	// Song is the song the event is about. For now_playing it is the new current song, or null.
+	Song *SongConfig `json:"song,omitempty"`
+	// User is the user who joined or left.
+	User *WSUser `json:"user,omitempty"`
+	// QueueOrder lists the queued song IDs in play order after the event, or is null if the queue didn't change.
+	QueueOrder    []string       `json:"queueOrder"`
+	PlaybackError *PlaybackError `json:"playbackError,omitempty"`
+	Reason        string         `json:"reason,omitempty"`
+	// SkipTally is the vote to skip Song after a skip_vote event.
+	SkipTally *SkipTally `json:"skipTally,omitempty"`
+	// LockedUntil is when a queue locked by a queue_reordered event goes back to voting.
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+}
+
+// sortedQueue returns the queued songs in the order they will be played.
+func sortedQueue(queue SongPriorityQueue) []*SongConfig {
+	sorted := make(SongPriorityQueue, len(queue))
+	copy(sorted, queue)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted.Less(i, j)
+	})
+	return sorted
+}
+
+func queueOrder(queue SongPriorityQueue) []string {
+	order := []string{}
+	for _, song := range sortedQueue(queue) {
+		order = append(order, song.SongID)
+	}
+	return order
+}
+
+// emit adds a delta event to the current write to room, to be numbered and broadcast
+// once the room is stored. Outside a write the room is stored and the event sent straight away.
+// It must be called with the mutex held.
+func (ws *WSServer) emit(room *RoomConfig, event RoomEvent) {
+	if ws.write != nil && ws.write.room == room {
+		ws.write.events = append(ws.write.events, event)
+		return
+	}
+	if !ws.commit(&roomWrite{room: room, events: []RoomEvent{event}}) {
+		log.Printf("Dropped %s event in room %s, the room was changed by another instance", event.Event, room.RoomName)
+	}
+}
+
+// emitQueueEvent emits an event that changed the queue, attaching the new queue order.
+// It must be called with the mutex held.
+func (ws *WSServer) emitQueueEvent(room *RoomConfig, event string, sender WSUser, song *SongConfig) {
+	ws.reorderQueue(room)
+	ws.emit(room, RoomEvent{Event: event, Sender: sender, Song: song, QueueOrder: queueOrder(room.SongQueue)})
+}
+
+// emitNowPlaying announces the room's new current song, which has just left the queue.
+// It must be called with the mutex held.
+func (ws *WSServer) emitNowPlaying(room *RoomConfig, sender WSUser) {
+	ws.emitQueueEvent(room, EventNowPlaying, sender, room.CurrentSong)
+}
+
+// snapshot builds a full state frame for recipient. joined is only set on the snapshot
+// sent to a client that just joined, so no one else learns its credentials.
+// It must be called with the mutex held.
+func (ws *WSServer) snapshot(room *RoomConfig, recipient WSUser, joined *joinedUser) BroadcastMessage {
+	message := BroadcastMessage{
+		Type:              FrameState,
+		Seq:               room.Seq,
+		Sender:            recipient,
+		RoomName:          room.RoomName,
+		CurrentSongQueue:  sortedQueue(room.SongQueue),
+		CurrentSong:       room.CurrentSong,
+		ConnectedUserList: room.ConnectedUserList,
+	}
+	if ws.queueLocked(room) {
+		lockedUntil := room.LockedUntil
+		message.QueueLockedUntil = &lockedUntil
+	}
+	if len(room.SkipVotes) > 0 {
+		tally := skipTally(room)
+		message.SkipTally = &tally
+	}
+	if joined != nil {
+		message.ConnectionID = joined.ConnectionID
+		message.ReconnectToken = joined.ReconnectToken
+	}
+	return message
+}
+
+// sendSnapshot writes a full state frame to a single connection.
+func (ws *WSServer) sendSnapshot(roomName string, conn *websocket.Conn, joined *joinedUser) {
+	ws.mutex.Lock()
+	room, roomExists := ws.roomConfigMap[roomName]
+	if !roomExists {
+		ws.mutex.Unlock()
+		return
+	}
+	data, err := json.Marshal(ws.snapshot(room, room.Clients[conn], joined))
+	ws.mutex.Unlock()
+	if err != nil {
+		log.Printf("Error marshaling snapshot: %v\n", err)
+		return
+	}
+	ws.writeTo(conn, data)
+}
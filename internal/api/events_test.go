@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestEventsAreNumberedAcrossInstances(t *testing.T) {
+	store, broker := NewMemoryStore(), NewMemoryBroker()
+	a, srvA := newTestInstance(t, NewRealClock(), store, broker)
+	b, srvB := newTestInstance(t, NewRealClock(), store, broker)
+	if _, err := a.WSServer.addRoom("party", testHost("host"), RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	updates, unsubscribe := broker.Subscribe(roomChannel("party"))
+	defer unsubscribe()
+
+	_, hostID := joinTestRoom(t, a, srvA, "party", "host")
+	_, guestID := joinTestRoom(t, b, srvB, "party", "guest")
+	first, second := testSong("first", time.Minute), testSong("second", time.Minute)
+	if err := a.WSServer.addSuggestedSong(first.Track, "party", hostID); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WSServer.addSuggestedSong(second.Track, "party", guestID); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.WSServer.voteForSong(second.SongID, "party", hostID, VoteUp); err != nil {
+		t.Fatal(err)
+	}
+	a.WSServer.mutex.Lock()
+	err := a.WSServer.updateRoom("party", func(room *RoomConfig) error {
+		a.WSServer.deleteRoom(room)
+		return nil
+	})
+	a.WSServer.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each instance publishes its own events, so they can reach the broker out of order.
+	events := map[uint64]string{}
+	var closedSeq uint64
+	timeout := time.After(2 * time.Second)
+	for closedSeq == 0 || len(events) < 2 || !numberedWithoutGaps(events) {
+		select {
+		case update := <-updates:
+			var wrapped roomUpdate
+			var event RoomEvent
+			if json.Unmarshal(update, &wrapped) != nil || json.Unmarshal(wrapped.Payload, &event) != nil || event.Type != FrameEvent {
+				continue
+			}
+			if previous, seen := events[event.Seq]; seen {
+				t.Fatalf("%s and %s both have seq %d", previous, event.Event, event.Seq)
+			}
+			events[event.Seq] = event.Event
+			if event.Event == EventRoomClosed {
+				closedSeq = event.Seq
+			}
+		case <-timeout:
+			t.Fatalf("events are missing or out of sequence: %v", events)
+		}
+	}
+	for seq := range events {
+		if seq > closedSeq {
+			t.Errorf("%s has seq %d, after room_closed's %d", events[seq], seq, closedSeq)
+		}
+	}
+}
+
+// numberedWithoutGaps reports whether events holds one event for every seq between its lowest and highest.
+func numberedWithoutGaps(events map[uint64]string) bool {
+	seqs := make([]uint64, 0, len(events))
+	for seq := range events {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs[len(seqs)-1]-seqs[0] == uint64(len(seqs)-1)
+}
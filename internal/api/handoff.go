@@ -0,0 +1,181 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Host handoff policies decide what happens to a room when its host leaves.
+const (
+	// HostHandoffPromoteLongest makes the longest-connected guest the host.
+	HostHandoffPromoteLongest = "promote_longest"
+	// HostHandoffPromoteCoHost makes the co-host the host, falling back to the longest-connected guest.
+	HostHandoffPromoteCoHost = "promote_cohost"
+	// HostHandoffOwnerless keeps the room without a host for ownerlessGracePeriod, waiting for the owner to return.
+	HostHandoffOwnerless = "ownerless"
+)
+
+const ownerlessGracePeriod = 5 * time.Minute
+
+const (
+	EventRoleChanged = "role_changed"
+	// EventRoomClosed tells every instance that the room was deleted.
+	EventRoomClosed = "room_closed"
+)
+
+func validHostHandoff(policy string) bool {
+	switch policy {
+	case HostHandoffPromoteLongest, HostHandoffPromoteCoHost, HostHandoffOwnerless:
+		return true
+	}
+	return false
+}
+
+// handOffHost decides who runs the room after its host left for good.
+// It must be called with the mutex held, after the host was removed from the room.
+func (ws *WSServer) handOffHost(room *RoomConfig, host WSUser) {
+	room.IsHostPresent = false
+	if len(room.ConnectedUserList) == 0 {
+		log.Printf("Host left empty room %s. Deleting room.\n", room.RoomName)
+		ws.deleteRoom(room)
+		return
+	}
+
+	if room.HostHandoff == HostHandoffOwnerless {
+		log.Printf("Host left room %s, keeping it open for %s", room.RoomName, ownerlessGracePeriod)
+		roomName := room.RoomName
+		ws.stopOwnerlessTimer(room)
+		room.ownerlessTimer = ws.clock.AfterFunc(ownerlessGracePeriod, func() {
+			ws.expireOwnerless(roomName)
+		})
+		host.IsAlive = false
+		ws.emit(room, RoomEvent{Event: EventUserLeft, Sender: host, User: &host})
+		return
+	}
+
+	successor := hostSuccessor(room)
+	log.Printf("Host left room %s, handing it to %s", room.RoomName, successor.UserName)
+	room.StandInRole = successor.UserType
+	ws.setUserType(room, successor.UserName, UserTypeHost)
+	room.Host = *successor
+	room.IsHostPresent = true
+	host.IsAlive = false
+	ws.emit(room, RoomEvent{Event: EventUserLeft, Sender: host, User: &host})
+	ws.emitRoleChanged(room, host, *successor)
+}
+
+// hostSuccessor picks the user to promote: the co-host if the room's policy asks for one,
+// otherwise the longest-connected user. Connected users are preferred over ones who may
+// not come back. ConnectedUserList is kept in join order.
+// It must be called with the mutex held, with at least one user in the room.
+func hostSuccessor(room *RoomConfig) *WSUser {
+	if room.HostHandoff == HostHandoffPromoteCoHost {
+		for _, user := range room.ConnectedUserList {
+			if user.UserType == UserTypeCoHost && user.IsAlive {
+				return user
+			}
+		}
+	}
+	for _, user := range room.ConnectedUserList {
+		if user.IsAlive {
+			return user
+		}
+	}
+	return room.ConnectedUserList[0]
+}
+
+// expireOwnerless deletes a room whose owner didn't come back in time.
+func (ws *WSServer) expireOwnerless(roomName string) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	if _, roomExists := ws.roomConfigMap[roomName]; !roomExists {
+		return
+	}
+	err := ws.updateRoom(roomName, func(room *RoomConfig) error {
+		if room.IsHostPresent {
+			return nil
+		}
+		log.Printf("Owner didn't return to room %s. Deleting room.\n", roomName)
+		ws.deleteRoom(room)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Could not delete room %s: %v", roomName, err)
+	}
+}
+
+// stopOwnerlessTimer must be called with the mutex held.
+func (ws *WSServer) stopOwnerlessTimer(room *RoomConfig) {
+	if room.ownerlessTimer != nil {
+		room.ownerlessTimer.Stop()
+		room.ownerlessTimer = nil
+	}
+}
+
+// takeHost makes a joining user the host. When the owner comes back, whoever was
+// promoted in their absence gets back the role they had before.
+// It must be called with the mutex held.
+func (ws *WSServer) takeHost(room *RoomConfig, owner WSUser) {
+	ws.stopOwnerlessTimer(room)
+	if room.IsHostPresent && room.Host.UserName != owner.UserName {
+		standIn := room.Host
+		role := room.StandInRole
+		if role == "" || role == UserTypeHost {
+			role = UserTypeGuest
+		}
+		ws.setUserType(room, standIn.UserName, role)
+		if listed := listedUser(room, standIn.UserName); listed != nil {
+			ws.emitRoleChanged(room, owner, *listed)
+		}
+		log.Printf("Owner %s reclaimed room %s from %s", owner.UserName, room.RoomName, standIn.UserName)
+	}
+	room.Host = owner
+	room.IsHostPresent = true
+	room.StandInRole = ""
+}
+
+// deleteRoom closes every connection to the room and deletes it everywhere, once the
+// current write to it is stored. Other instances disconnect their clients when the
+// room_closed update reaches them.
+// It must be called with the mutex held.
+func (ws *WSServer) deleteRoom(room *RoomConfig) {
+	if ws.write != nil && ws.write.room == room {
+		ws.write.deleted = true
+		return
+	}
+	ws.commitDelete(room)
+}
+
+// commitDelete deletes the room, unless another instance stored it since it was read.
+// It must be called with the mutex held.
+func (ws *WSServer) commitDelete(room *RoomConfig) bool {
+	// Numbered before the delete, which drops the room's counter with it.
+	seq := ws.nextSeq(room)
+	err := ws.roomStore.DeleteRoom(room)
+	if errors.Is(err, errRoomChanged) {
+		return false
+	}
+	if err != nil {
+		log.Printf("Could not delete stored room %s: %v", room.RoomName, err)
+	}
+	disconnectClients(room)
+	// Not emitted, which would store the room again. The broadcaster still publishes
+	// it after closeRoom, as it drains the channel before it stops.
+	ws.broadcast(room.RoomName, RoomEvent{Type: FrameEvent, Event: EventRoomClosed, RoomName: room.RoomName, Seq: seq, Sender: room.Host})
+	ws.closeRoom(room.RoomName)
+	return true
+}
+
+// disconnectClients closes this instance's connections to the room.
+// It must be called with the mutex held.
+func disconnectClients(room *RoomConfig) {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "room closed")
+	for conn := range room.Clients {
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(controlWriteWait))
+		conn.Close()
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/oauth2"
+)
+
+// roomHost returns the name of room party's host and the role userName is listed with.
+func roomHost(ws *WSServer, userName string) (string, string) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	room := ws.roomConfigMap["party"]
+	role := ""
+	if listed := listedUser(room, userName); listed != nil {
+		role = listed.UserType
+	}
+	return room.Host.UserName, role
+}
+
+func TestHandOffToCoHost(t *testing.T) {
+	spotify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer owner-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"status": 401, "message": "bad token"}}`))
+			return
+		}
+		w.Write([]byte(fakeSearchResponse))
+	}))
+	defer spotify.Close()
+
+	a, srv := newTestAPI(t, NewRealClock())
+	a.SpotifyBaseURL = spotify.URL + "/"
+	ws := a.WSServer
+	owner := testHost("owner")
+	if _, err := ws.addRoom("party", owner, RoomOptions{HostHandoff: HostHandoffPromoteCoHost}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Tokens.Save(owner.UserID, &oauth2.Token{AccessToken: "owner-token"}); err != nil {
+		t.Fatal(err)
+	}
+	ownerConn, ownerID := joinTestRoom(t, a, srv, "party", "owner")
+	joinTestRoom(t, a, srv, "party", "guest")
+	_, coHostID := joinTestRoom(t, a, srv, "party", "co")
+	if err := ws.setRole("party", ownerID, "co", UserTypeCoHost); err != nil {
+		t.Fatal(err)
+	}
+
+	// The owner leaves for good, handing the room to the co-host.
+	ownerConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	waitFor(t, "the handoff", func() bool {
+		host, _ := roomHost(ws, "co")
+		return host == "co"
+	})
+
+	// The co-host has no Spotify account of their own; the room keeps using the owner's.
+	query := url.Values{"roomName": {"party"}, "connectionID": {coHostID}, "q": {"rick astley"}}
+	rec := httptest.NewRecorder()
+	a.SearchHandler(rec, httptest.NewRequest(http.MethodGet, "/search?"+query.Encode(), nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("search after handoff: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	joinTestRoom(t, a, srv, "party", "owner")
+	if host, role := roomHost(ws, "co"); host != "owner" || role != UserTypeCoHost {
+		t.Errorf("after the owner returned, host = %s and co is %s; want owner and %s", host, role, UserTypeCoHost)
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultPingInterval = 25 * time.Second
+	defaultPongTimeout  = 60 * time.Second
+	// controlWriteWait bounds how long a ping may block on a stuck connection.
+	controlWriteWait = 10 * time.Second
+)
+
+// HeartbeatConfig controls how the server detects dead connections. Every PingInterval
+// each client is pinged, and a client that has sent nothing, not even a pong, for
+// PongTimeout is disconnected.
+type HeartbeatConfig struct {
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+}
+
+func DefaultHeartbeatConfig() HeartbeatConfig {
+	return HeartbeatConfig{
+		PingInterval: defaultPingInterval,
+		PongTimeout:  defaultPongTimeout,
+	}
+}
+
+func (c HeartbeatConfig) validate() error {
+	if c.PingInterval <= 0 {
+		return fmt.Errorf("ping interval must be positive")
+	}
+	if c.PongTimeout <= c.PingInterval {
+		return fmt.Errorf("pong timeout must be longer than the ping interval")
+	}
+	return nil
+}
+
+// SetHeartbeat changes the heartbeat timing for connections that join afterwards.
+func (ws *WSServer) SetHeartbeat(config HeartbeatConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	ws.heartbeat = config
+	return nil
+}
+
+// schedulePing must be called with the mutex held.
+func (ws *WSServer) schedulePing(conn *websocket.Conn, client *clientConn) {
+	client.pingTimer = ws.clock.AfterFunc(ws.heartbeat.PingInterval, func() {
+		ws.heartbeatTick(conn)
+	})
+}
+
+// touch records that the client is still there. Any frame counts, not just pongs.
+func (ws *WSServer) touch(conn *websocket.Conn) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if client, exists := ws.conns[conn]; exists {
+		client.lastSeen = ws.clock.Now()
+	}
+}
+
+// heartbeatTick reaps the connection if it has gone quiet for too long, and pings it otherwise.
+// Closing a reaped connection ends its read loop, which removes the user the usual way.
+func (ws *WSServer) heartbeatTick(conn *websocket.Conn) {
+	ws.mutex.Lock()
+	client, exists := ws.conns[conn]
+	if !exists {
+		ws.mutex.Unlock()
+		return
+	}
+	if ws.clock.Now().Sub(client.lastSeen) > ws.heartbeat.PongTimeout {
+		ws.mutex.Unlock()
+		log.Printf("Connection %s missed its heartbeat, closing it", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	ws.schedulePing(conn, client)
+	ws.mutex.Unlock()
+
+	// Control frames may be written concurrently with the connection's other writes.
+	if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(controlWriteWait)); err != nil {
+		log.Printf("Error pinging client: %v\n", err)
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// waitFor polls cond until it holds, failing the test if it doesn't within a couple of seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// lastSeen returns when the server last heard from userName in room party.
+func lastSeen(ws *WSServer, userName string) time.Time {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	for conn, user := range ws.roomConfigMap["party"].Clients {
+		if client, exists := ws.conns[conn]; exists && user.UserName == userName {
+			return client.lastSeen
+		}
+	}
+	return time.Time{}
+}
+
+func TestHeartbeat(t *testing.T) {
+	clock := newFakeClock()
+	a, srv := newTestAPI(t, clock)
+	ws := a.WSServer
+	config := HeartbeatConfig{PingInterval: 25 * time.Second, PongTimeout: 60 * time.Second}
+	if err := ws.SetHeartbeat(config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.addRoom("party", testHost("host"), RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	host, _ := joinTestRoom(t, a, srv, "party", "host")
+	hostPings := make(chan struct{}, 8)
+	host.SetPingHandler(func(data string) error {
+		hostPings <- struct{}{}
+		return host.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	hostEvents := make(chan RoomEvent, 16)
+	go func() {
+		for {
+			_, data, err := host.ReadMessage()
+			if err != nil {
+				return
+			}
+			var event RoomEvent
+			if json.Unmarshal(data, &event) == nil && event.Type == FrameEvent {
+				hostEvents <- event
+			}
+		}
+	}()
+
+	// The guest's client has hung: it never answers a ping.
+	guest, _ := joinTestRoom(t, a, srv, "party", "guest")
+	guestPings := make(chan struct{}, 8)
+	guest.SetPingHandler(func(string) error {
+		guestPings <- struct{}{}
+		return nil
+	})
+	guestClosed := make(chan struct{})
+	go func() {
+		defer close(guestClosed)
+		for {
+			if _, _, err := guest.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	expectPing := func(pings chan struct{}, who string) {
+		t.Helper()
+		select {
+		case <-pings:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s wasn't pinged", who)
+		}
+	}
+	for elapsed := config.PingInterval; elapsed <= 2*config.PingInterval; elapsed += config.PingInterval {
+		clock.Advance(config.PingInterval)
+		expectPing(hostPings, "host")
+		expectPing(guestPings, "guest")
+		now := clock.Now()
+		waitFor(t, "the host's pong", func() bool { return lastSeen(ws, "host").Equal(now) })
+	}
+	select {
+	case <-guestClosed:
+		t.Fatal("guest was disconnected before its pong timeout")
+	default:
+	}
+
+	// Silent for 75s now, longer than the pong timeout.
+	clock.Advance(config.PingInterval)
+	select {
+	case <-guestClosed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("silent guest wasn't disconnected")
+	}
+	expectPing(hostPings, "host")
+
+	for {
+		select {
+		case event := <-hostEvents:
+			if event.Event != EventUserDisconnected {
+				continue
+			}
+			if event.User == nil || event.User.UserName != "guest" || event.User.IsAlive {
+				t.Fatalf("user_disconnected for %+v", event.User)
+			}
+			ws.mutex.Lock()
+			defer ws.mutex.Unlock()
+			room := ws.roomConfigMap["party"]
+			if member, exists := room.members["guest"]; !exists || !member.isDisconnected() {
+				t.Error("guest wasn't held for reconnection")
+			}
+			if listed := listedUser(room, "guest"); listed == nil || listed.IsAlive {
+				t.Errorf("guest listed as %+v", listed)
+			}
+			return
+		case <-time.After(2 * time.Second):
+			t.Fatal("host wasn't told the guest disconnected")
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"woahtify-backend/utils"
+)
+
+const (
+	// guestIDPrefix keeps guest IDs apart from Spotify user IDs.
+	guestIDPrefix      = "guest:"
+	guestTokenLifetime = 24 * time.Hour
+	maxNicknameLength  = 32
+)
+
+// Identity is who a client is, as proven by a session or guest token.
+// UserID is the Spotify user ID for signed-in users and a random guest ID otherwise.
+type Identity struct {
+	UserID   string
+	UserName string
+}
+
+// GuestClaims are the claims of a guest token. The subject is the guest's random ID.
+type GuestClaims struct {
+	Nickname string `json:"nickname"`
+	jwt.RegisteredClaims
+}
+
+// validNickname trims nickname and checks it is fit to show to the room.
+func validNickname(nickname string) (string, error) {
+	nickname = strings.TrimSpace(nickname)
+	if nickname == "" {
+		return "", fmt.Errorf("a nickname is required")
+	}
+	if utf8.RuneCountInString(nickname) > maxNicknameLength {
+		return "", fmt.Errorf("nicknames can be at most %d characters", maxNicknameLength)
+	}
+	for _, r := range nickname {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("nicknames can't contain control characters")
+		}
+	}
+	return nickname, nil
+}
+
+// GenerateGuestToken issues a guest token for nickname under a new random guest ID.
+func (a *API) GenerateGuestToken(nickname string) (string, *Identity, time.Time, error) {
+	nickname, err := validNickname(nickname)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	guestID, err := utils.GenerateSecureRandomString(16)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	identity := &Identity{UserID: guestIDPrefix + guestID, UserName: nickname}
+	expiresAt := a.JWTKeys.clock.Now().Add(guestTokenLifetime)
+	token, err := a.JWTKeys.Sign(&GuestClaims{
+		Nickname:         nickname,
+		RegisteredClaims: a.JWTKeys.registeredClaims(identity.UserID, expiresAt),
+	})
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	return token, identity, expiresAt, nil
+}
+
+// ValidateGuestToken checks a guest token and returns the guest's identity.
+func (a *API) ValidateGuestToken(tokenString string) (*Identity, error) {
+	var claims GuestClaims
+	if err := a.JWTKeys.Parse(tokenString, &claims); err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+	// Session tokens are signed with the same keys, so make sure this really is a guest token.
+	if claims.Nickname == "" || !strings.HasPrefix(claims.Subject, guestIDPrefix) {
+		return nil, fmt.Errorf("not a guest token")
+	}
+	return &Identity{UserID: claims.Subject, UserName: claims.Nickname}, nil
+}
+
+// identify resolves a session or guest token to the identity it proves.
+func (a *API) identify(tokenString string) (*Identity, error) {
+	if identity, err := a.ValidateGuestToken(tokenString); err == nil {
+		return identity, nil
+	}
+	session, err := a.ValidateJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{UserID: session.UserID, UserName: session.UserName}, nil
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidNickname(t *testing.T) {
+	tests := []struct {
+		nickname string
+		want     string
+		wantErr  bool
+	}{
+		{nickname: "  dj  ", want: "dj"},
+		{nickname: strings.Repeat("é", maxNicknameLength), want: strings.Repeat("é", maxNicknameLength)},
+		{nickname: "   ", wantErr: true},
+		{nickname: strings.Repeat("a", maxNicknameLength+1), wantErr: true},
+		{nickname: "dj\nhost", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := validNickname(test.nickname)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("validNickname(%q) = %q, %v, want %q with error %t", test.nickname, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestGuestIdentity(t *testing.T) {
+	a, _ := newTestAPI(t, NewRealClock())
+
+	token, identity, _, err := a.GenerateGuestToken(" dj ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.UserName != "dj" || !strings.HasPrefix(identity.UserID, guestIDPrefix) {
+		t.Errorf("guest identity = %+v, want nickname dj under a guest ID", identity)
+	}
+	validated, err := a.ValidateGuestToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *validated != *identity {
+		t.Errorf("token proves %+v, want %+v", validated, identity)
+	}
+	if identified, err := a.identify(token); err != nil || *identified != *identity {
+		t.Errorf("identify = %+v, %v, want %+v", identified, err, identity)
+	}
+
+	// Nicknames aren't unique; the guest ID tells guests apart.
+	_, other, _, err := a.GenerateGuestToken("dj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.UserID == identity.UserID {
+		t.Error("two guests got the same ID")
+	}
+
+	session := signTestToken(t, a.JWTKeys, time.Hour)
+	if _, err := a.ValidateGuestToken(session); err == nil {
+		t.Error("session token accepted as a guest token")
+	}
+	if _, err := a.ValidateGuestToken(token + "x"); err == nil {
+		t.Error("tampered guest token accepted")
+	}
+}
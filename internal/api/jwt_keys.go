@@ -0,0 +1,118 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minJWTKeyLength is the shortest HS256 secret we accept (256 bits).
+const minJWTKeyLength = 32
+
+// JWTKeySet signs and validates the JWTs issued by the server.
+// Tokens are signed with the active key and carry its kid; every configured key
+// is still accepted for validation, so keys can be rotated without logging users out.
+type JWTKeySet struct {
+	keys      map[string][]byte
+	activeKID string
+	issuer    string
+	audience  string
+	clock     Clock
+}
+
+// NewJWTKeySet validates the key configuration. activeKID must name one of keys.
+func NewJWTKeySet(keys map[string][]byte, activeKID, issuer, audience string, clock Clock) (*JWTKeySet, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one JWT signing key is required")
+	}
+	for kid, key := range keys {
+		if len(key) < minJWTKeyLength {
+			return nil, fmt.Errorf("JWT key %s must be at least %d bytes", kid, minJWTKeyLength)
+		}
+	}
+	if _, exists := keys[activeKID]; !exists {
+		return nil, fmt.Errorf("active JWT key %s is not configured", activeKID)
+	}
+	return &JWTKeySet{
+		keys:      keys,
+		activeKID: activeKID,
+		issuer:    issuer,
+		audience:  audience,
+		clock:     clock,
+	}, nil
+}
+
+// ParseJWTKeys parses a "kid1:secret1,kid2:secret2" key list, as found in the JWT_KEYS env variable.
+func ParseJWTKeys(spec string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, secret, found := strings.Cut(entry, ":")
+		if !found || kid == "" || secret == "" {
+			return nil, fmt.Errorf("invalid JWT key entry %q, expected kid:secret", entry)
+		}
+		if _, exists := keys[kid]; exists {
+			return nil, fmt.Errorf("duplicate JWT key id %s", kid)
+		}
+		keys[kid] = []byte(secret)
+	}
+	return keys, nil
+}
+
+// registeredClaims returns the standard claims for a token about subject that expires at expiresAt.
+func (ks *JWTKeySet) registeredClaims(subject string, expiresAt time.Time) jwt.RegisteredClaims {
+	now := ks.clock.Now()
+	return jwt.RegisteredClaims{
+		Issuer:    ks.issuer,
+		Subject:   subject,
+		Audience:  jwt.ClaimStrings{ks.audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+}
+
+// Sign signs claims with the active key.
+func (ks *JWTKeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = ks.activeKID
+	return token.SignedString(ks.keys[ks.activeKID])
+}
+
+// Parse validates tokenString into claims. It rejects any algorithm other than HS256,
+// unknown key ids, and tokens with a missing or wrong exp, iat, iss or aud.
+func (ks *JWTKeySet) Parse(tokenString string, claims jwt.Claims) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, ok := t.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("missing kid")
+		}
+		key, exists := ks.keys[kid]
+		if !exists {
+			return nil, fmt.Errorf("unknown kid %s", kid)
+		}
+		return key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(ks.issuer),
+		jwt.WithAudience(ks.audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithTimeFunc(ks.clock.Now),
+	)
+	if err != nil {
+		return err
+	}
+	if !token.Valid {
+		return fmt.Errorf("invalid token")
+	}
+	// WithIssuedAt only checks an iat that is present.
+	if issuedAt, err := claims.GetIssuedAt(); err != nil || issuedAt == nil {
+		return fmt.Errorf("missing iat")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	oldJWTKey = []byte("old-key-0123456789abcdef01234567")
+	newJWTKey = []byte("new-key-0123456789abcdef01234567")
+)
+
+func newTestKeySet(t *testing.T, keys map[string][]byte, activeKID string, clock Clock) *JWTKeySet {
+	t.Helper()
+	ks, err := NewJWTKeySet(keys, activeKID, "test-issuer", "test-audience", clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func signTestToken(t *testing.T, ks *JWTKeySet, expiresIn time.Duration) string {
+	t.Helper()
+	token, err := ks.Sign(&SessionClaims{
+		SessionID:        "session",
+		RegisteredClaims: ks.registeredClaims("spotify-user", ks.clock.Now().Add(expiresIn)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestJWTKeySetSignsWithActiveKey(t *testing.T) {
+	ks := newTestKeySet(t, map[string][]byte{"old": oldJWTKey, "new": newJWTKey}, "new", newFakeClock())
+	tokenString := signTestToken(t, ks, time.Hour)
+
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, &SessionClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := token.Header["kid"]; kid != "new" {
+		t.Errorf("kid = %v, want new", kid)
+	}
+	if alg := token.Header["alg"]; alg != "HS256" {
+		t.Errorf("alg = %v, want HS256", alg)
+	}
+	var claims SessionClaims
+	if err := ks.Parse(tokenString, &claims); err != nil {
+		t.Fatalf("own token rejected: %v", err)
+	}
+	if claims.SessionID != "session" || claims.Subject != "spotify-user" {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+func TestJWTKeySetRotation(t *testing.T) {
+	clock := newFakeClock()
+	before := newTestKeySet(t, map[string][]byte{"old": oldJWTKey}, "old", clock)
+	during := newTestKeySet(t, map[string][]byte{"old": oldJWTKey, "new": newJWTKey}, "new", clock)
+	after := newTestKeySet(t, map[string][]byte{"new": newJWTKey}, "new", clock)
+	oldToken := signTestToken(t, before, time.Hour)
+
+	if err := during.Parse(oldToken, &SessionClaims{}); err != nil {
+		t.Errorf("token signed with a retired key was rejected during rotation: %v", err)
+	}
+	if err := after.Parse(oldToken, &SessionClaims{}); err == nil {
+		t.Error("token signed with an unknown kid was accepted")
+	}
+	if err := after.Parse(signTestToken(t, during, time.Hour), &SessionClaims{}); err != nil {
+		t.Errorf("token signed with the new key was rejected: %v", err)
+	}
+}
+
+func TestJWTKeySetRejects(t *testing.T) {
+	clock := newFakeClock()
+	ks := newTestKeySet(t, map[string][]byte{"new": newJWTKey}, "new", clock)
+	now := clock.Now()
+	valid := jwt.RegisteredClaims{
+		Issuer:    "test-issuer",
+		Subject:   "spotify-user",
+		Audience:  jwt.ClaimStrings{"test-audience"},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, change func(*jwt.RegisteredClaims)) string {
+		claims := valid
+		if change != nil {
+			change(&claims)
+		}
+		token := jwt.NewWithClaims(method, &SessionClaims{SessionID: "session", RegisteredClaims: claims})
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"HS512", sign(jwt.SigningMethodHS512, "new", newJWTKey, nil)},
+		{"alg none", sign(jwt.SigningMethodNone, "new", jwt.UnsafeAllowNoneSignatureType, nil)},
+		{"missing kid", sign(jwt.SigningMethodHS256, "", newJWTKey, nil)},
+		{"unknown kid", sign(jwt.SigningMethodHS256, "other", newJWTKey, nil)},
+		{"wrong key", sign(jwt.SigningMethodHS256, "new", oldJWTKey, nil)},
+		{"wrong issuer", sign(jwt.SigningMethodHS256, "new", newJWTKey, func(c *jwt.RegisteredClaims) { c.Issuer = "someone-else" })},
+		{"wrong audience", sign(jwt.SigningMethodHS256, "new", newJWTKey, func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"another-app"} })},
+		{"missing exp", sign(jwt.SigningMethodHS256, "new", newJWTKey, func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil })},
+		{"missing iat", sign(jwt.SigningMethodHS256, "new", newJWTKey, func(c *jwt.RegisteredClaims) { c.IssuedAt = nil })},
+		{"issued in the future", sign(jwt.SigningMethodHS256, "new", newJWTKey, func(c *jwt.RegisteredClaims) { c.IssuedAt = jwt.NewNumericDate(now.Add(time.Minute)) })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ks.Parse(tt.token, &SessionClaims{}); err == nil {
+				t.Error("token was accepted")
+			}
+		})
+	}
+}
+
+func TestJWTKeySetRejectsExpiredTokens(t *testing.T) {
+	clock := newFakeClock()
+	ks := newTestKeySet(t, map[string][]byte{"new": newJWTKey}, "new", clock)
+	token := signTestToken(t, ks, time.Hour)
+
+	clock.Advance(time.Hour - time.Second)
+	if err := ks.Parse(token, &SessionClaims{}); err != nil {
+		t.Fatalf("token rejected before it expired: %v", err)
+	}
+	clock.Advance(time.Second)
+	if err := ks.Parse(token, &SessionClaims{}); err == nil {
+		t.Error("expired token was accepted")
+	}
+}
+
+func TestParseJWTKeys(t *testing.T) {
+	keys, err := ParseJWTKeys(" old:first-secret , new:second:secret ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(keys["old"]) != "first-secret" || string(keys["new"]) != "second:secret" || len(keys) != 2 {
+		t.Errorf("keys = %q", keys)
+	}
+	for _, spec := range []string{"no-secret", ":secret", "kid:", "a:one,a:two"} {
+		if _, err := ParseJWTKeys(spec); err == nil {
+			t.Errorf("%q was accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"woahtify-backend/utils"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	loginStateKeyPrefix = "login-state:"
+	loginStateTTL       = 10 * time.Minute
+	pkceVerifierLength  = 64
+)
+
+// loginAttempt is what we remember about a login between /login and the Spotify callback.
+type loginAttempt struct {
+	CodeVerifier string `json:"codeVerifier,omitempty"`
+}
+
+// LoginStateStore issues a random OAuth state per login attempt. Each state expires
+// after loginStateTTL and can be redeemed only once.
+type LoginStateStore struct {
+	store Store
+}
+
+func NewLoginStateStore(store Store) *LoginStateStore {
+	return &LoginStateStore{store: store}
+}
+
+func loginStateKey(state string) string {
+	return loginStateKeyPrefix + state
+}
+
+// Begin starts a login attempt and returns its state along with the options to add to the
+// authorization URL. With usePKCE the attempt also gets an S256 code challenge.
+func (ls *LoginStateStore) Begin(usePKCE bool) (string, []oauth2.AuthCodeOption, error) {
+	state, err := utils.GenerateSecureRandomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	attempt := loginAttempt{}
+	opts := []oauth2.AuthCodeOption{}
+	if usePKCE {
+		attempt.CodeVerifier, err = utils.GenerateSecureRandomString(pkceVerifierLength)
+		if err != nil {
+			return "", nil, err
+		}
+		challenge := sha256.Sum256([]byte(attempt.CodeVerifier))
+		opts = append(opts,
+			oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		)
+	}
+
+	data, err := json.Marshal(attempt)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := ls.store.Set(loginStateKey(state), string(data), loginStateTTL); err != nil {
+		return "", nil, err
+	}
+	return state, opts, nil
+}
+
+// Redeem consumes the state and returns the options needed to exchange the authorization code.
+func (ls *LoginStateStore) Redeem(state string) ([]oauth2.AuthCodeOption, error) {
+	if state == "" {
+		return nil, fmt.Errorf("missing state")
+	}
+	data, exists, err := ls.store.Take(loginStateKey(state))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("unknown or expired state")
+	}
+
+	var attempt loginAttempt
+	if err := json.Unmarshal([]byte(data), &attempt); err != nil {
+		return nil, err
+	}
+	opts := []oauth2.AuthCodeOption{}
+	if attempt.CodeVerifier != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", attempt.CodeVerifier))
+	}
+	return opts, nil
+}
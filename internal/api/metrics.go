@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// Metrics counts what happens to the frames the server sends. It is safe for concurrent use.
+type Metrics struct {
+	framesSent atomic.Uint64
+	// roomBacklogDrops are updates dropped because a room's broadcast channel was full.
+	roomBacklogDrops atomic.Uint64
+	// slowConsumerDrops are frames dropped because a client's outbound queue was full.
+	slowConsumerDrops atomic.Uint64
+	// writeErrorDrops are frames that failed to write, usually to a connection that just went away.
+	writeErrorDrops       atomic.Uint64
+	slowConsumerEvictions atomic.Uint64
+}
+
+// MetricsSnapshot is a point-in-time copy of Metrics.
+type MetricsSnapshot struct {
+	FramesSent            uint64 `json:"framesSent"`
+	RoomBacklogDrops      uint64 `json:"roomBacklogDrops"`
+	SlowConsumerDrops     uint64 `json:"slowConsumerDrops"`
+	WriteErrorDrops       uint64 `json:"writeErrorDrops"`
+	SlowConsumerEvictions uint64 `json:"slowConsumerEvictions"`
+}
+
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	return MetricsSnapshot{
+		FramesSent:            m.framesSent.Load(),
+		RoomBacklogDrops:      m.roomBacklogDrops.Load(),
+		SlowConsumerDrops:     m.slowConsumerDrops.Load(),
+		WriteErrorDrops:       m.writeErrorDrops.Load(),
+		SlowConsumerEvictions: m.slowConsumerEvictions.Load(),
+	}
+}
+
+// MetricsHandler reports the WebSocket frame counters.
+func (a *API) MetricsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.WSServer.metrics.Snapshot())
+}
@@ -1,14 +1,22 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strings"
-	"time"
 
 	"log"
 )
 
+type sessionContextKey struct{}
+
+// SessionFromContext returns the session attached by AuthMiddleware.
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+	session, ok := ctx.Value(sessionContextKey{}).(*Session)
+	return session, ok
+}
+
 // AuthMiddleware checks for a valid authentication token in the request header.
 func (a *API) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -27,24 +35,21 @@ func (a *API) AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		spotify_token_info, err := ValidateJWT(parts[1])
+		session, err := a.ValidateJWT(parts[1])
 		if err != nil {
 			log.Printf("Error while trying to validate JWT %s", err)
-		}
-
-		if _, exists := a.AccessTokenMap[spotify_token_info.UserName]; !exists {
-			w.WriteHeader(http.StatusForbidden)
-			json.NewEncoder(w).Encode(ErrorResponse{Error: "Access denied: Invalid user"})
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "Login required: Invalid or expired session"})
 			return
 		}
 
-		if time.Now().Unix() > spotify_token_info.Expiry {
+		if !a.Tokens.Has(session.UserID) {
 			w.WriteHeader(http.StatusForbidden)
-			json.NewEncoder(w).Encode(ErrorResponse{Error: "Access denied: Token expired"})
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "Access denied: Invalid user"})
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, session)))
 	})
 }
 
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxCloseReasonLength is the most a close frame can carry after its status code.
+const maxCloseReasonLength = 123
+
+// closeReason shortens reason to fit in a close frame. It cuts on a rune boundary, since
+// clients reject a close frame whose reason isn't valid UTF-8.
+func closeReason(reason string) string {
+	if len(reason) <= maxCloseReasonLength {
+		return reason
+	}
+	end := maxCloseReasonLength
+	for end > 0 && !utf8.RuneStart(reason[end]) {
+		end--
+	}
+	return reason[:end]
+}
+
+const EventUserKicked = "user_kicked"
+
+// RoomBan keeps a user out of a room. A ban matches by username, and also by
+// user ID, so a new name doesn't get a signed-in user back in.
+type RoomBan struct {
+	UserName string    `json:"userName"`
+	UserID   string    `json:"userId,omitempty"`
+	Reason   string    `json:"reason"`
+	BannedBy string    `json:"bannedBy"`
+	BannedAt time.Time `json:"bannedAt"`
+}
+
+func (b RoomBan) matches(userName, userID string) bool {
+	if strings.EqualFold(b.UserName, userName) {
+		return true
+	}
+	return b.UserID != "" && b.UserID == userID
+}
+
+// findBan must be called with the mutex held.
+func findBan(room *RoomConfig, userName, userID string) *RoomBan {
+	for i := range room.Bans {
+		if room.Bans[i].matches(userName, userID) {
+			return &room.Bans[i]
+		}
+	}
+	return nil
+}
+
+// kickUser removes userName from the room straight away, closing their connections
+// with reason. With ban set they are also kept from joining again.
+func (ws *WSServer) kickUser(roomName, connectionID, userName, reason string, ban bool) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	return ws.updateRoom(roomName, func(room *RoomConfig) error {
+		user, err := ws.authorize(room, connectionID, PermissionKick)
+		if err != nil {
+			return err
+		}
+		listed := listedUser(room, userName)
+		if listed == nil {
+			return fmt.Errorf("user %s is not in the room", userName)
+		}
+		target := *listed
+		if !outranks(user, target) {
+			return fmt.Errorf("a %s can't kick a %s", user.UserType, target.UserType)
+		}
+
+		if ban && findBan(room, target.UserName, target.UserID) == nil {
+			room.Bans = append(room.Bans, RoomBan{
+				UserName: target.UserName,
+				UserID:   target.UserID,
+				Reason:   reason,
+				BannedBy: user.UserName,
+				BannedAt: ws.clock.Now(),
+			})
+		}
+
+		kickReason := reason
+		if kickReason == "" {
+			kickReason = "removed from the room"
+		}
+		ws.afterWrite(room, func() {
+			ws.closeUserConns(room, target.UserName, kickReason)
+		})
+
+		ws.forgetUser(room, target)
+		log.Printf("User %s kicked %s from room %s (ban: %t): %s", user.UserName, target.UserName, roomName, ban, kickReason)
+		target.IsAlive = false
+		ws.emit(room, RoomEvent{Event: EventUserKicked, Sender: user, User: &target, Reason: kickReason})
+		ws.recountSkipVotes(room, target)
+		return nil
+	})
+}
+
+// closeUserConns closes the local connections of userName with the kick close code.
+// It must be called with the mutex held.
+func (ws *WSServer) closeUserConns(room *RoomConfig, userName, reason string) {
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, closeReason(reason))
+	for conn, client := range room.Clients {
+		if client.UserName != userName {
+			continue
+		}
+		// The kicked connection's read loop finds it already gone and stops there.
+		ws.untrackConn(conn)
+		delete(room.Clients, conn)
+		for connID, c := range room.ConnectionIDUserMap {
+			if c == conn {
+				delete(room.ConnectionIDUserMap, connID)
+			}
+		}
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(controlWriteWait))
+		conn.Close()
+	}
+}
+
+// unbanUser lifts every ban on userName.
+func (ws *WSServer) unbanUser(roomName, connectionID, userName string) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	return ws.updateRoom(roomName, func(room *RoomConfig) error {
+		user, err := ws.authorize(room, connectionID, PermissionKick)
+		if err != nil {
+			return err
+		}
+
+		bans := []RoomBan{}
+		for _, ban := range room.Bans {
+			if !strings.EqualFold(ban.UserName, userName) {
+				bans = append(bans, ban)
+			}
+		}
+		if len(bans) == len(room.Bans) {
+			return fmt.Errorf("user %s is not banned", userName)
+		}
+		room.Bans = bans
+		log.Printf("User %s lifted the ban on %s in room %s", user.UserName, userName, roomName)
+		ws.markChanged(room)
+		return nil
+	})
+}
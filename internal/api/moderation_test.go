@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCloseReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason string
+		want   string
+	}{
+		{"short", "spamming", "spamming"},
+		{"exact", strings.Repeat("a", maxCloseReasonLength), strings.Repeat("a", maxCloseReasonLength)},
+		{"ascii", strings.Repeat("a", 200), strings.Repeat("a", maxCloseReasonLength)},
+		// The 62nd two-byte rune and the 41st three-byte rune cross the limit.
+		{"two-byte runes", strings.Repeat("é", 100), strings.Repeat("é", 61)},
+		{"three-byte runes", "a" + strings.Repeat("€", 50), "a" + strings.Repeat("€", 40)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := closeReason(test.reason)
+			if got != test.want {
+				t.Errorf("closeReason = %q, want %q", got, test.want)
+			}
+			if !utf8.ValidString(got) || len(got) > maxCloseReasonLength {
+				t.Errorf("closeReason = %q is not a valid close reason", got)
+			}
+		})
+	}
+}
+
+func TestKickClosesConnectionOnOtherInstance(t *testing.T) {
+	store, broker := NewMemoryStore(), NewMemoryBroker()
+	a, srvA := newTestInstance(t, NewRealClock(), store, broker)
+	b, srvB := newTestInstance(t, NewRealClock(), store, broker)
+	if _, err := a.WSServer.addRoom("party", testHost("host"), RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, hostID := joinTestRoom(t, a, srvA, "party", "host")
+	guest, _ := joinTestRoom(t, b, srvB, "party", "guest")
+
+	if err := a.WSServer.kickUser("party", hostID, "guest", "spamming", true); err != nil {
+		t.Fatal(err)
+	}
+	guest.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := guest.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "spamming" {
+			t.Fatalf("guest connection ended with %v, want a policy violation close with the kick reason", err)
+		}
+		break
+	}
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	playbackTimeout = 10 * time.Second
+	// pausedRecheckInterval is how long to wait before checking again on a song the host paused.
+	pausedRecheckInterval = 15 * time.Second
+	// songEndTolerance absorbs the drift between our clock and Spotify's progress.
+	songEndTolerance = 2 * time.Second
+)
+
+const (
+	PlaybackErrorNotConnected    = "not_connected"
+	PlaybackErrorNotPlayable     = "not_playable"
+	PlaybackErrorNoActiveDevice  = "no_active_device"
+	PlaybackErrorPremiumRequired = "premium_required"
+	PlaybackErrorFailed          = "playback_failed"
+)
+
+// PlaybackError is reported to the room when the host's device can't play a song.
+type PlaybackError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *PlaybackError) Error() string {
+	return e.Message
+}
+
+// PlaybackState is what the host's device reports it is playing.
+type PlaybackState struct {
+	TrackURI string
+	Progress time.Duration
+	Playing  bool
+}
+
+// Player starts songs on the host's device and reports what it is playing.
+type Player interface {
+	Play(ctx context.Context, host WSUser, song *SongConfig) error
+	CurrentlyPlaying(ctx context.Context, host WSUser) (*PlaybackState, error)
+}
+
+// spotifyPlayer plays songs on the host's active Spotify Connect device.
+type spotifyPlayer struct {
+	api *API
+}
+
+func (p *spotifyPlayer) Play(ctx context.Context, host WSUser, song *SongConfig) error {
+	if song.Track.URI == "" {
+		return &PlaybackError{Code: PlaybackErrorNotPlayable, Message: "song " + song.SongName + " has no Spotify track"}
+	}
+	client, err := p.api.hostSpotifyClient(ctx, host)
+	if err != nil {
+		return &PlaybackError{Code: PlaybackErrorNotConnected, Message: err.Error()}
+	}
+	err = client.PlayOpt(ctx, &spotify.PlayOptions{URIs: []spotify.URI{spotify.URI(song.Track.URI)}})
+	return toPlaybackError(err)
+}
+
+func (p *spotifyPlayer) CurrentlyPlaying(ctx context.Context, host WSUser) (*PlaybackState, error) {
+	client, err := p.api.hostSpotifyClient(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	current, err := client.PlayerCurrentlyPlaying(ctx)
+	if err != nil {
+		return nil, err
+	}
+	state := &PlaybackState{
+		Progress: time.Duration(current.Progress) * time.Millisecond,
+		Playing:  current.Playing,
+	}
+	if current.Item != nil {
+		state.TrackURI = string(current.Item.URI)
+	}
+	return state, nil
+}
+
+// toPlaybackError maps Spotify Web API failures onto the typed errors sent to clients.
+func toPlaybackError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var spotifyErr spotify.Error
+	if !errors.As(err, &spotifyErr) {
+		return &PlaybackError{Code: PlaybackErrorFailed, Message: err.Error()}
+	}
+	switch spotifyErr.Status {
+	case http.StatusNotFound:
+		return &PlaybackError{Code: PlaybackErrorNoActiveDevice, Message: "No active Spotify device found, start Spotify on the host's device"}
+	case http.StatusForbidden:
+		return &PlaybackError{Code: PlaybackErrorPremiumRequired, Message: "Spotify Premium is required to control playback"}
+	default:
+		return &PlaybackError{Code: PlaybackErrorFailed, Message: spotifyErr.Message}
+	}
+}
+
+// startSong makes song the room's current song, starts the room's playback clock
+// and starts the song on the host's device.
+// It must be called with the mutex held; playback itself runs in the background.
+func (ws *WSServer) startSong(room *RoomConfig, song *SongConfig) {
+	room.CurrentSong = song
+	room.SongStartedAt = ws.clock.Now()
+	ws.stopSongTimer(room)
+	ws.checkPlayback(room)
+	if ws.player == nil {
+		return
+	}
+	roomName, account := room.RoomName, spotifyAccount(room)
+	ws.afterWrite(room, func() {
+		go ws.playSong(roomName, account, song)
+	})
+}
+
+// advanceSong plays the next queued song, or clears the current song if the queue is empty.
+// It must be called with the mutex held.
+func (ws *WSServer) advanceSong(room *RoomConfig) *SongConfig {
+	ws.stopSongTimer(room)
+	room.CurrentSong = nil
+	room.SkipVotes = nil
+	if len(room.SongQueue) == 0 {
+		return nil
+	}
+	// Strategies that weigh waiting time may have moved another song to the front.
+	ws.reorderQueue(room)
+	nextSong := heap.Pop(&room.SongQueue).(*SongConfig)
+	ws.forgetManualOrder(room, nextSong.SongID)
+	ws.startSong(room, nextSong)
+	return nextSong
+}
+
+// scheduleSongEnd arms the room's playback clock to fire once the current song has played for d.
+// Songs without a known duration never end on their own.
+// It must be called with the mutex held.
+func (ws *WSServer) scheduleSongEnd(room *RoomConfig, d time.Duration) {
+	ws.stopSongTimer(room)
+	song := room.CurrentSong
+	if song == nil || song.Track.DurationMs == 0 {
+		return
+	}
+	roomName, startedAt := room.RoomName, room.SongStartedAt
+	room.songTimer = ws.clock.AfterFunc(d, func() {
+		ws.onSongEnd(roomName, song.SongID, startedAt)
+	})
+}
+
+// stopSongTimer must be called with the mutex held.
+func (ws *WSServer) stopSongTimer(room *RoomConfig) {
+	if room.songTimer != nil {
+		room.songTimer.Stop()
+		room.songTimer = nil
+	}
+}
+
+// resumeSongClock arms the playback clock for a song that started at room.SongStartedAt,
+// accounting for the time it was already playing.
+// It must be called with the mutex held.
+func (ws *WSServer) resumeSongClock(room *RoomConfig) {
+	if room.CurrentSong == nil || room.SongStartedAt.IsZero() {
+		return
+	}
+	duration := time.Duration(room.CurrentSong.Track.DurationMs) * time.Millisecond
+	remaining := duration - ws.clock.Now().Sub(room.SongStartedAt)
+	if remaining < 0 {
+		remaining = 0
+	}
+	ws.scheduleSongEnd(room, remaining)
+}
+
+// ownsPlayback claims or renews the room's playback for this instance, and reports whether
+// this instance is the one that runs the room's playback clock.
+// It must be called with the mutex held.
+func (ws *WSServer) ownsPlayback(room *RoomConfig) bool {
+	owned, err := ws.roomStore.ClaimPlayback(room.RoomName, ws.instanceID)
+	if err != nil {
+		// Keep the music going through a store outage.
+		log.Printf("Could not claim playback of room %s: %v", room.RoomName, err)
+		return true
+	}
+	return owned
+}
+
+// checkPlayback arms the playback clock if this instance owns the room's playback and
+// it isn't running yet, and stops it if another instance took over.
+// It must be called with the mutex held.
+func (ws *WSServer) checkPlayback(room *RoomConfig) {
+	if room.CurrentSong == nil {
+		return
+	}
+	if !ws.ownsPlayback(room) {
+		ws.stopSongTimer(room)
+		return
+	}
+	if room.songTimer == nil {
+		ws.resumeSongClock(room)
+	}
+}
+
+// onSongEnd runs when the playback clock expires. When the host's token is available it first
+// checks Spotify, so a song the host paused or seeked in is given the time it actually needs.
+func (ws *WSServer) onSongEnd(roomName, songID string, startedAt time.Time) {
+	ws.mutex.Lock()
+	room, roomExists := ws.roomConfigMap[roomName]
+	if !roomExists || !isCurrentSong(room, songID, startedAt) {
+		ws.mutex.Unlock()
+		return
+	}
+	if !ws.ownsPlayback(room) {
+		// Another instance took over the room's playback clock.
+		ws.stopSongTimer(room)
+		ws.mutex.Unlock()
+		return
+	}
+	account, song := spotifyAccount(room), room.CurrentSong
+	ws.mutex.Unlock()
+
+	var state *PlaybackState
+	if ws.player != nil && song.Track.URI != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), playbackTimeout)
+		var err error
+		state, err = ws.player.CurrentlyPlaying(ctx, account)
+		cancel()
+		if err != nil {
+			log.Printf("Could not read playback state for room %s, trusting the clock: %v", roomName, err)
+			state = nil
+		}
+	}
+
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if _, roomExists := ws.roomConfigMap[roomName]; !roomExists {
+		return
+	}
+	err := ws.updateRoom(roomName, func(room *RoomConfig) error {
+		// The room may have moved on while we were asking Spotify.
+		if !isCurrentSong(room, songID, startedAt) {
+			return nil
+		}
+
+		if state != nil && state.TrackURI == song.Track.URI {
+			duration := time.Duration(song.Track.DurationMs) * time.Millisecond
+			remaining := duration - state.Progress
+			if !state.Playing && state.Progress > 0 {
+				ws.scheduleSongEnd(room, pausedRecheckInterval)
+				return nil
+			}
+			if state.Playing && remaining > songEndTolerance {
+				ws.scheduleSongEnd(room, remaining)
+				return nil
+			}
+		}
+
+		nextSong := ws.advanceSong(room)
+		if nextSong != nil {
+			log.Printf("Finished %s, playing %s in room %s", song.SongName, nextSong.SongName, roomName)
+		} else {
+			log.Printf("Finished %s, no more songs in room %s", song.SongName, roomName)
+		}
+		ws.emitNowPlaying(room, room.Host)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Could not end %s in room %s: %v", song.SongName, roomName, err)
+	}
+}
+
+func isCurrentSong(room *RoomConfig, songID string, startedAt time.Time) bool {
+	return room.CurrentSong != nil && room.CurrentSong.SongID == songID && room.SongStartedAt.Equal(startedAt)
+}
+
+func (ws *WSServer) playSong(roomName string, account WSUser, song *SongConfig) {
+	ctx, cancel := context.WithTimeout(context.Background(), playbackTimeout)
+	defer cancel()
+
+	err := ws.player.Play(ctx, account, song)
+	if err == nil {
+		log.Printf("Playing %s in room %s", song.SongName, roomName)
+		return
+	}
+	log.Printf("Could not play %s in room %s: %v", song.SongName, roomName, err)
+
+	var playbackErr *PlaybackError
+	if !errors.As(err, &playbackErr) {
+		playbackErr = &PlaybackError{Code: PlaybackErrorFailed, Message: err.Error()}
+	}
+
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if _, roomExists := ws.roomConfigMap[roomName]; !roomExists {
+		return
+	}
+	err = ws.updateRoom(roomName, func(room *RoomConfig) error {
+		if room.CurrentSong == nil || room.CurrentSong.SongID != song.SongID {
+			// The room moved on while we were talking to Spotify.
+			return nil
+		}
+		ws.emit(room, RoomEvent{Event: EventPlaybackError, Sender: room.Host, Song: song, PlaybackError: playbackErr})
+		return nil
+	})
+	if err != nil {
+		log.Printf("Could not report the playback error in room %s: %v", roomName, err)
+	}
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePlayer reports whatever playback state the test sets.
+type fakePlayer struct {
+	mutex sync.Mutex
+	state *PlaybackState
+}
+
+func (p *fakePlayer) Play(ctx context.Context, host WSUser, song *SongConfig) error {
+	return nil
+}
+
+func (p *fakePlayer) CurrentlyPlaying(ctx context.Context, host WSUser) (*PlaybackState, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.state == nil {
+		return &PlaybackState{}, nil
+	}
+	state := *p.state
+	return &state, nil
+}
+
+func (p *fakePlayer) setState(state *PlaybackState) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.state = state
+}
+
+func testSong(id string, duration time.Duration) *SongConfig {
+	return &SongConfig{
+		SongID:   "spotify:track:" + id,
+		SongName: id,
+		Track:    Track{URI: "spotify:track:" + id, Title: id, DurationMs: int(duration / time.Millisecond)},
+	}
+}
+
+// newPlaybackTestServer creates a room that starts playing first, with queued waiting behind it.
+func newPlaybackTestServer(t *testing.T, clock *fakeClock, player Player, first *SongConfig, queued ...*SongConfig) *WSServer {
+	t.Helper()
+	ws := NewWSServer(NewRoomStore(NewMemoryStore()), NewMemoryBroker(), player, nil, clock)
+	if _, err := ws.addRoom("party", testHost("host"), RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	room := ws.roomConfigMap["party"]
+	for _, song := range queued {
+		heap.Push(&room.SongQueue, song)
+	}
+	ws.startSong(room, first)
+	ws.persistRoom(room)
+	return ws
+}
+
+// currentSongID returns the ID of the song playing in room party, or "" if none is.
+func currentSongID(ws *WSServer) string {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	room, exists := ws.roomConfigMap["party"]
+	if !exists || room.CurrentSong == nil {
+		return ""
+	}
+	return room.CurrentSong.SongID
+}
+
+func TestScheduleSongEnd(t *testing.T) {
+	clock := newFakeClock()
+	ws := newPlaybackTestServer(t, clock, nil, testSong("first", 3*time.Second), testSong("second", 5*time.Second))
+
+	clock.Advance(3*time.Second - time.Millisecond)
+	if got := currentSongID(ws); got != "spotify:track:first" {
+		t.Fatalf("before the song ends, playing %q", got)
+	}
+	clock.Advance(time.Millisecond)
+	if got := currentSongID(ws); got != "spotify:track:second" {
+		t.Fatalf("after the song ends, playing %q", got)
+	}
+}
+
+func TestScheduleSongEndWithoutDuration(t *testing.T) {
+	clock := newFakeClock()
+	ws := newPlaybackTestServer(t, clock, nil, testSong("unknown", 0), testSong("second", 5*time.Second))
+
+	clock.Advance(time.Hour)
+	if got := currentSongID(ws); got != "spotify:track:unknown" {
+		t.Fatalf("a song without a duration ended, playing %q", got)
+	}
+}
+
+func TestOnSongEnd(t *testing.T) {
+	const duration = 10 * time.Second
+	tests := []struct {
+		name string
+		// state is what the host's device reports when the clock first runs out.
+		state *PlaybackState
+		// stillPlaying is how long after that the song is still expected to play.
+		stillPlaying time.Duration
+	}{
+		{
+			name:  "advances when the song is over",
+			state: &PlaybackState{TrackURI: "spotify:track:first", Progress: duration, Playing: true},
+		},
+		{
+			name:  "trusts the clock when the device plays something else",
+			state: &PlaybackState{TrackURI: "spotify:track:other", Progress: time.Second, Playing: true},
+		},
+		{
+			name:         "waits for a paused song",
+			state:        &PlaybackState{TrackURI: "spotify:track:first", Progress: 4 * time.Second, Playing: false},
+			stillPlaying: pausedRecheckInterval,
+		},
+		{
+			name:         "waits for a song that was seeked back",
+			state:        &PlaybackState{TrackURI: "spotify:track:first", Progress: 4 * time.Second, Playing: true},
+			stillPlaying: 6 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			player := &fakePlayer{}
+			ws := newPlaybackTestServer(t, clock, player, testSong("first", duration), testSong("second", duration))
+
+			player.setState(tt.state)
+			clock.Advance(duration)
+			if tt.stillPlaying > 0 {
+				if got := currentSongID(ws); got != "spotify:track:first" {
+					t.Fatalf("song ended early, playing %q", got)
+				}
+				// By the next check the song has really finished.
+				player.setState(&PlaybackState{TrackURI: "spotify:track:first", Progress: duration, Playing: true})
+				clock.Advance(tt.stillPlaying - time.Millisecond)
+				if got := currentSongID(ws); got != "spotify:track:first" {
+					t.Fatalf("song ended before the re-check, playing %q", got)
+				}
+				clock.Advance(time.Millisecond)
+			}
+			if got := currentSongID(ws); got != "spotify:track:second" {
+				t.Fatalf("playing %q, want the next song", got)
+			}
+		})
+	}
+}
+
+func TestOnSongEndWithEmptyQueue(t *testing.T) {
+	clock := newFakeClock()
+	ws := newPlaybackTestServer(t, clock, nil, testSong("last", 3*time.Second))
+
+	clock.Advance(3 * time.Second)
+	if got := currentSongID(ws); got != "" {
+		t.Fatalf("playing %q after the last song ended", got)
+	}
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if room := ws.roomConfigMap["party"]; room.songTimer != nil {
+		t.Error("playback clock still armed with nothing playing")
+	}
+}
+
+func TestResumeSongClock(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryStore()
+	stored := newRoomConfig("party", testHost("host"), "secret")
+	stored.CurrentSong = testSong("first", 5*time.Second)
+	stored.SongStartedAt = clock.Now().Add(-2 * time.Second)
+	if err := NewRoomStore(store).SaveRoom(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := NewWSServer(NewRoomStore(store), NewMemoryBroker(), nil, nil, clock)
+	clock.Advance(3*time.Second - time.Millisecond)
+	if got := currentSongID(ws); got != "spotify:track:first" {
+		t.Fatalf("restored song ended early, playing %q", got)
+	}
+	clock.Advance(time.Millisecond)
+	if got := currentSongID(ws); got != "" {
+		t.Fatalf("restored song didn't end, playing %q", got)
+	}
+}
+
+func TestPlaybackClockRunsOnOneInstance(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryStore()
+	stored := newRoomConfig("party", testHost("host"), "secret")
+	stored.CurrentSong = testSong("first", 5*time.Second)
+	stored.SongStartedAt = clock.Now()
+	if err := NewRoomStore(store).SaveRoom(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	instances := []*WSServer{
+		NewWSServer(NewRoomStore(store), NewMemoryBroker(), nil, nil, clock),
+		NewWSServer(NewRoomStore(store), NewMemoryBroker(), nil, nil, clock),
+	}
+	armed := 0
+	for _, ws := range instances {
+		ws.mutex.Lock()
+		if ws.roomConfigMap["party"].songTimer != nil {
+			armed++
+		}
+		ws.mutex.Unlock()
+	}
+	if armed != 1 {
+		t.Fatalf("playback clock armed on %d instances, want 1", armed)
+	}
+}
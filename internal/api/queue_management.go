@@ -0,0 +1,254 @@
+package api
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	defaultQueueLock = 15 * time.Minute
+	maxQueueLock     = time.Hour
+)
+
+const (
+	// EventQueueReordered is sent when a song is pinned or unpinned, or the queue is locked or unlocked.
+	EventQueueReordered = "queue_reordered"
+)
+
+// Reasons carried by queue_reordered and song_removed events.
+const (
+	ReasonPinned    = "pinned"
+	ReasonUnpinned  = "unpinned"
+	ReasonLocked    = "locked"
+	ReasonUnlocked  = "unlocked"
+	ReasonRemoved   = "removed"
+	ReasonDownvoted = "downvoted"
+)
+
+// queueLocked reports whether the room's locked order is in force.
+// It must be called with the mutex held.
+func (ws *WSServer) queueLocked(room *RoomConfig) bool {
+	return len(room.LockedOrder) > 0 && ws.clock.Now().Before(room.LockedUntil)
+}
+
+// rankManualOrder gives pinned songs, then songs in a locked order, a Rank that puts
+// them ahead of the strategy's order. Every other song gets no Rank.
+// It must be called with the mutex held.
+func (ws *WSServer) rankManualOrder(room *RoomConfig) {
+	ranks := make(map[string]int)
+	for _, songID := range room.PinnedOrder {
+		ranks[songID] = len(ranks) + 1
+	}
+	if ws.queueLocked(room) {
+		for _, songID := range room.LockedOrder {
+			if _, pinned := ranks[songID]; !pinned {
+				ranks[songID] = len(ranks) + 1
+			}
+		}
+	}
+	for _, song := range room.SongQueue {
+		song.Rank = ranks[song.SongID]
+		song.Pinned = containsSong(room.PinnedOrder, song.SongID)
+	}
+}
+
+// manuallyOrdered reports whether song's place was set by hand, so votes don't move or remove it.
+// It must be called with the mutex held.
+func (ws *WSServer) manuallyOrdered(room *RoomConfig, song *SongConfig) bool {
+	return containsSong(room.PinnedOrder, song.SongID) || (ws.queueLocked(room) && containsSong(room.LockedOrder, song.SongID))
+}
+
+func containsSong(songIDs []string, songID string) bool {
+	for _, id := range songIDs {
+		if id == songID {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutSong(songIDs []string, songID string) []string {
+	result := []string{}
+	for _, id := range songIDs {
+		if id != songID {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// forgetManualOrder drops a song that left the queue from the pinned and locked orders.
+// It must be called with the mutex held.
+func (ws *WSServer) forgetManualOrder(room *RoomConfig, songID string) {
+	room.PinnedOrder = withoutSong(room.PinnedOrder, songID)
+	room.LockedOrder = withoutSong(room.LockedOrder, songID)
+}
+
+// dropQueuedSong takes song out of the queue and broadcasts its removal.
+// It must be called with the mutex held.
+func (ws *WSServer) dropQueuedSong(room *RoomConfig, song *SongConfig, sender WSUser, reason string) {
+	heap.Remove(&room.SongQueue, song.Index)
+	ws.forgetManualOrder(room, song.SongID)
+	ws.reorderQueue(room)
+	ws.emit(room, RoomEvent{Event: EventSongRemoved, Sender: sender, Song: song, QueueOrder: queueOrder(room.SongQueue), Reason: reason})
+}
+
+// removeSong takes a song out of the queue.
+func (ws *WSServer) removeSong(songID, roomName, connectionID string) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	return ws.updateRoom(roomName, func(room *RoomConfig) error {
+		user, err := ws.authorize(room, connectionID, PermissionRemoveSong)
+		if err != nil {
+			return err
+		}
+		song := room.SongQueue.find(songID)
+		if song == nil {
+			return fmt.Errorf("song isn't in the queue")
+		}
+
+		log.Printf("User %s removed %s from room %s", user.UserName, song.SongName, roomName)
+		ws.dropQueuedSong(room, song, user, ReasonRemoved)
+		return nil
+	})
+}
+
+// pinSong makes a queued song play next, ahead of the votes. Songs pinned earlier play first.
+// Unpinning returns the song to its voted place.
+func (ws *WSServer) pinSong(songID, roomName, connectionID string, pinned bool) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	return ws.updateRoom(roomName, func(room *RoomConfig) error {
+		user, err := ws.authorize(room, connectionID, PermissionPin)
+		if err != nil {
+			return err
+		}
+		song := room.SongQueue.find(songID)
+		if song == nil {
+			return fmt.Errorf("song isn't in the queue")
+		}
+		if pinned == containsSong(room.PinnedOrder, song.SongID) {
+			return nil
+		}
+
+		reason := ReasonUnpinned
+		room.PinnedOrder = withoutSong(room.PinnedOrder, song.SongID)
+		if pinned {
+			reason = ReasonPinned
+			room.PinnedOrder = append(room.PinnedOrder, song.SongID)
+		}
+		ws.reorderQueue(room)
+		ws.emit(room, RoomEvent{Event: EventQueueReordered, Sender: user, Song: song, QueueOrder: queueOrder(room.SongQueue), Reason: reason})
+		return nil
+	})
+}
+
+// lockQueue fixes the queue to order, a list of queued song IDs, for duration. Votes are
+// still counted but don't move the locked songs; new suggestions queue after them.
+// An empty order unlocks the queue.
+func (ws *WSServer) lockQueue(roomName, connectionID string, order []string, duration time.Duration) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	return ws.updateRoom(roomName, func(room *RoomConfig) error {
+		user, err := ws.authorize(room, connectionID, PermissionPin)
+		if err != nil {
+			return err
+		}
+		if duration <= 0 {
+			duration = defaultQueueLock
+		}
+		if duration > maxQueueLock {
+			return fmt.Errorf("the queue can be locked for at most %s", maxQueueLock)
+		}
+		locked := []string{}
+		for _, songID := range order {
+			song := room.SongQueue.find(songID)
+			if song == nil {
+				return fmt.Errorf("song %s isn't in the queue", songID)
+			}
+			if containsSong(locked, song.SongID) {
+				return fmt.Errorf("song %s is listed twice", songID)
+			}
+			locked = append(locked, song.SongID)
+		}
+
+		ws.stopLockTimer(room)
+		reason := ReasonUnlocked
+		room.LockedOrder = nil
+		room.LockedUntil = time.Time{}
+		if len(locked) > 0 {
+			reason = ReasonLocked
+			room.LockedOrder = locked
+			room.LockedUntil = ws.clock.Now().Add(duration)
+			ws.scheduleUnlock(room)
+		}
+		log.Printf("User %s %s the queue of room %s", user.UserName, reason, roomName)
+		ws.emitQueueLock(room, user, reason)
+		return nil
+	})
+}
+
+// emitQueueLock must be called with the mutex held.
+func (ws *WSServer) emitQueueLock(room *RoomConfig, sender WSUser, reason string) {
+	ws.reorderQueue(room)
+	event := RoomEvent{Event: EventQueueReordered, Sender: sender, QueueOrder: queueOrder(room.SongQueue), Reason: reason}
+	if reason == ReasonLocked {
+		lockedUntil := room.LockedUntil
+		event.LockedUntil = &lockedUntil
+	}
+	ws.emit(room, event)
+}
+
+// scheduleUnlock arms the timer that hands the queue back to voting.
+// It must be called with the mutex held.
+func (ws *WSServer) scheduleUnlock(room *RoomConfig) {
+	ws.stopLockTimer(room)
+	if room.LockedUntil.IsZero() {
+		return
+	}
+	roomName, lockedUntil := room.RoomName, room.LockedUntil
+	remaining := lockedUntil.Sub(ws.clock.Now())
+	if remaining < 0 {
+		remaining = 0
+	}
+	room.lockTimer = ws.clock.AfterFunc(remaining, func() {
+		ws.unlockQueue(roomName, lockedUntil)
+	})
+}
+
+// unlockQueue runs when a lock expires, unless the lock was replaced in the meantime.
+func (ws *WSServer) unlockQueue(roomName string, lockedUntil time.Time) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	if _, roomExists := ws.roomConfigMap[roomName]; !roomExists {
+		return
+	}
+	err := ws.updateRoom(roomName, func(room *RoomConfig) error {
+		if !room.LockedUntil.Equal(lockedUntil) {
+			return nil
+		}
+		room.lockTimer = nil
+		room.LockedOrder = nil
+		room.LockedUntil = time.Time{}
+		log.Printf("Queue lock expired in room %s", roomName)
+		ws.emitQueueLock(room, room.Host, ReasonUnlocked)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Could not unlock the queue of room %s: %v", roomName, err)
+	}
+}
+
+// stopLockTimer must be called with the mutex held.
+func (ws *WSServer) stopLockTimer(room *RoomConfig) {
+	if room.lockTimer != nil {
+		room.lockTimer.Stop()
+		room.lockTimer = nil
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"container/heap"
+	"testing"
+	"time"
+)
+
+func TestModeratorPinsAndLocksQueue(t *testing.T) {
+	a, srv := newTestAPI(t, NewRealClock())
+	ws := a.WSServer
+	if _, err := ws.addRoom("party", testHost("host"), RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, hostID := joinTestRoom(t, a, srv, "party", "host")
+	_, modID := joinTestRoom(t, a, srv, "party", "mod")
+	_, guestID := joinTestRoom(t, a, srv, "party", "guest")
+	if err := ws.setRole("party", hostID, "mod", UserTypeModerator); err != nil {
+		t.Fatal(err)
+	}
+	first, second := testSong("first", time.Minute), testSong("second", time.Minute)
+	ws.mutex.Lock()
+	room := ws.roomConfigMap["party"]
+	heap.Push(&room.SongQueue, first)
+	heap.Push(&room.SongQueue, second)
+	ws.persistRoom(room)
+	ws.mutex.Unlock()
+
+	if err := ws.pinSong(second.SongID, "party", guestID, true); err == nil {
+		t.Error("guest pinned a song")
+	}
+	if err := ws.pinSong(second.SongID, "party", modID, true); err != nil {
+		t.Fatalf("moderator couldn't pin a song: %v", err)
+	}
+	if err := ws.lockQueue("party", modID, []string{second.SongID, first.SongID}, time.Minute); err != nil {
+		t.Fatalf("moderator couldn't lock the queue: %v", err)
+	}
+
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if order := queueOrder(room.SongQueue); order[0] != second.SongID {
+		t.Errorf("queue order = %v, want %s first", order, second.SongID)
+	}
+	if !ws.queueLocked(room) {
+		t.Error("queue isn't locked")
+	}
+}
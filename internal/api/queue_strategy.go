@@ -0,0 +1,110 @@
+package api
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// Queue strategies decide the order queued songs play in.
+const (
+	// QueueStrategyVotes plays the highest-scoring song first.
+	QueueStrategyVotes = "votes"
+	// QueueStrategyRoundRobin takes turns between suggesters, playing each one's best song in turn.
+	QueueStrategyRoundRobin = "round_robin"
+	// QueueStrategyTimeDecay plays by score, but songs gain a point for every timeDecayInterval they wait.
+	QueueStrategyTimeDecay = "time_decay"
+	// QueueStrategyFIFOBoost plays songs in the order they were suggested, and each extra vote
+	// moves a song fifoBoostPerVote further forward.
+	QueueStrategyFIFOBoost = "fifo_boost"
+)
+
+const (
+	timeDecayInterval = 10 * time.Minute
+	fifoBoostPerVote  = 5 * time.Minute
+)
+
+// QueueStrategy orders a room's queue. Songs with a higher Priority play first; equal
+// priorities fall back to score, then to the older suggestion.
+type QueueStrategy interface {
+	// Prioritize sets the Priority of every song in queue as of now.
+	Prioritize(queue []*SongConfig, now time.Time)
+}
+
+var queueStrategies = map[string]QueueStrategy{
+	QueueStrategyVotes:      votesStrategy{},
+	QueueStrategyRoundRobin: roundRobinStrategy{},
+	QueueStrategyTimeDecay:  timeDecayStrategy{},
+	QueueStrategyFIFOBoost:  fifoBoostStrategy{},
+}
+
+func validQueueStrategy(name string) bool {
+	_, exists := queueStrategies[name]
+	return exists
+}
+
+type votesStrategy struct{}
+
+func (votesStrategy) Prioritize(queue []*SongConfig, now time.Time) {
+	for _, song := range queue {
+		song.Priority = float64(song.Score)
+	}
+}
+
+// roundRobinStrategy ranks each suggester's songs by score, then plays everyone's first
+// song before anyone's second, so no single group can take over the queue.
+type roundRobinStrategy struct{}
+
+func (roundRobinStrategy) Prioritize(queue []*SongConfig, now time.Time) {
+	bySuggester := make(map[string][]*SongConfig)
+	for _, song := range queue {
+		bySuggester[song.SuggestedBy.UserName] = append(bySuggester[song.SuggestedBy.UserName], song)
+	}
+	for _, songs := range bySuggester {
+		sort.Slice(songs, func(i, j int) bool {
+			if songs[i].Score != songs[j].Score {
+				return songs[i].Score > songs[j].Score
+			}
+			return SongPriorityQueue(songs).olderFirst(i, j)
+		})
+		for round, song := range songs {
+			song.Priority = -float64(round)
+		}
+	}
+}
+
+type timeDecayStrategy struct{}
+
+func (timeDecayStrategy) Prioritize(queue []*SongConfig, now time.Time) {
+	for _, song := range queue {
+		waited := now.Sub(song.SuggestedTimestamp)
+		if waited < 0 {
+			waited = 0
+		}
+		song.Priority = float64(song.Score) + float64(waited)/float64(timeDecayInterval)
+	}
+}
+
+type fifoBoostStrategy struct{}
+
+func (fifoBoostStrategy) Prioritize(queue []*SongConfig, now time.Time) {
+	for _, song := range queue {
+		// The suggester's own vote doesn't count as a boost.
+		boost := time.Duration(song.Score-1) * fifoBoostPerVote
+		queuedAt := song.SuggestedTimestamp.Add(-boost)
+		song.Priority = -float64(queuedAt.UnixNano()) / float64(time.Second)
+	}
+}
+
+// reorderQueue reprioritizes the room's queue with its strategy, puts pinned and locked
+// songs first and restores the heap.
+// It must be called with the mutex held.
+func (ws *WSServer) reorderQueue(room *RoomConfig) {
+	strategy, exists := queueStrategies[room.QueueStrategy]
+	if !exists {
+		strategy = queueStrategies[QueueStrategyVotes]
+	}
+	strategy.Prioritize(room.SongQueue, ws.clock.Now())
+	ws.rankManualOrder(room)
+	heap.Init(&room.SongQueue)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var strategyNow = time.Date(2026, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+// queuedSong is a song suggested by suggester age before strategyNow with the given net score.
+func queuedSong(id, suggester string, score int, age time.Duration) *SongConfig {
+	return &SongConfig{
+		SongID:             id,
+		SuggestedBy:        WSUser{UserName: suggester},
+		SuggestedTimestamp: strategyNow.Add(-age),
+		Score:              score,
+	}
+}
+
+func TestQueueStrategies(t *testing.T) {
+	tests := []struct {
+		strategy string
+		queue    []*SongConfig
+		want     []string
+	}{
+		{
+			strategy: QueueStrategyVotes,
+			queue: []*SongConfig{
+				queuedSong("a", "alice", 1, 30*time.Minute),
+				queuedSong("b", "bob", 3, 20*time.Minute),
+				queuedSong("c", "carol", 3, 40*time.Minute),
+				queuedSong("d", "dave", -1, 50*time.Minute),
+			},
+			// Equal scores play the older suggestion first.
+			want: []string{"c", "b", "a", "d"},
+		},
+		{
+			strategy: QueueStrategyRoundRobin,
+			queue: []*SongConfig{
+				queuedSong("a1", "alice", 5, 30*time.Minute),
+				queuedSong("a2", "alice", 4, 20*time.Minute),
+				queuedSong("a3", "alice", 3, 10*time.Minute),
+				queuedSong("b1", "bob", 1, 25*time.Minute),
+				queuedSong("b2", "bob", 0, 5*time.Minute),
+			},
+			want: []string{"a1", "b1", "a2", "b2", "a3"},
+		},
+		{
+			strategy: QueueStrategyTimeDecay,
+			queue: []*SongConfig{
+				queuedSong("old", "alice", 1, 60*time.Minute),  // 1 + 6
+				queuedSong("popular", "bob", 5, 5*time.Minute), // 5 + 0.5
+				queuedSong("fresh", "carol", 2, 0),             // 2 + 0
+				queuedSong("mid", "dave", 3, 30*time.Minute),   // 3 + 3
+			},
+			want: []string{"old", "mid", "popular", "fresh"},
+		},
+		{
+			strategy: QueueStrategyFIFOBoost,
+			queue: []*SongConfig{
+				queuedSong("first", "alice", 1, 30*time.Minute),   // queued at -30m
+				queuedSong("second", "bob", 1, 20*time.Minute),    // -20m
+				queuedSong("boosted", "carol", 3, 15*time.Minute), // -15m - 10m
+				queuedSong("more", "dave", 5, 2*time.Minute),      // -2m - 20m
+				queuedSong("late", "erin", 0, 28*time.Minute),     // -28m + 5m
+			},
+			want: []string{"first", "boosted", "late", "more", "second"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.strategy, func(t *testing.T) {
+			queueStrategies[test.strategy].Prioritize(test.queue, strategyNow)
+			if got := queueOrder(test.queue); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("order = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
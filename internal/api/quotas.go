@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Quota error codes, sent to clients as the error code of a rejected suggestion.
+const (
+	QuotaQueueFull = "queue_full"
+	QuotaPerUser   = "suggestion_limit"
+	QuotaCooldown  = "suggestion_cooldown"
+)
+
+// SuggestionLimits cap how much of a room's queue a single user can fill. Zero means no limit.
+type SuggestionLimits struct {
+	MaxQueuedPerUser int           `json:"maxQueuedPerUser"`
+	Cooldown         time.Duration `json:"cooldown"`
+	MaxQueueLength   int           `json:"maxQueueLength"`
+}
+
+// DefaultSuggestionLimits are applied to rooms created without limits of their own.
+var DefaultSuggestionLimits = SuggestionLimits{
+	MaxQueuedPerUser: 5,
+	Cooldown:         30 * time.Second,
+	MaxQueueLength:   100,
+}
+
+func (l SuggestionLimits) validate() error {
+	if l.MaxQueuedPerUser < 0 || l.Cooldown < 0 || l.MaxQueueLength < 0 {
+		return fmt.Errorf("suggestion limits can't be negative")
+	}
+	return nil
+}
+
+// QuotaError rejects a suggestion that would break one of the room's SuggestionLimits.
+// RetryAfter is how long until the user may suggest again, or zero if waiting won't help.
+type QuotaError struct {
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return e.Message
+}
+
+// RetryAfterSeconds rounds RetryAfter up, so a client that waits that long is let through.
+func (e *QuotaError) RetryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+// checkSuggestionLimits returns a QuotaError if user may not add a new song to the queue now.
+// Users allowed to bypass quotas aren't limited.
+// It must be called with the mutex held.
+func (ws *WSServer) checkSuggestionLimits(room *RoomConfig, user WSUser) error {
+	if checkPermission(user, PermissionBypassQuota) == nil {
+		return nil
+	}
+	limits := room.SuggestionLimits
+	if limits.MaxQueueLength > 0 && len(room.SongQueue) >= limits.MaxQueueLength {
+		return &QuotaError{Code: QuotaQueueFull, Message: fmt.Sprintf("the queue is full at %d songs", limits.MaxQueueLength)}
+	}
+	if limits.MaxQueuedPerUser > 0 {
+		queued := 0
+		for _, song := range room.SongQueue {
+			if song.SuggestedBy.isEqual(user) {
+				queued++
+			}
+		}
+		if queued >= limits.MaxQueuedPerUser {
+			return &QuotaError{Code: QuotaPerUser, Message: fmt.Sprintf("you already have %d songs in the queue", queued)}
+		}
+	}
+	if last, exists := room.lastSuggestion[user.UserName]; exists && limits.Cooldown > 0 {
+		if wait := last.Add(limits.Cooldown).Sub(ws.clock.Now()); wait > 0 {
+			err := &QuotaError{Code: QuotaCooldown, RetryAfter: wait}
+			err.Message = fmt.Sprintf("you can suggest again in %s", formatWait(err.RetryAfterSeconds()))
+			return err
+		}
+	}
+	return nil
+}
+
+// formatWait formats seconds as m:ss.
+func formatWait(seconds int) string {
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckSuggestionLimits(t *testing.T) {
+	limits := SuggestionLimits{MaxQueuedPerUser: 2, Cooldown: 30 * time.Second, MaxQueueLength: 3}
+	guest := WSUser{UserName: "guest", UserType: UserTypeGuest}
+	tests := []struct {
+		name           string
+		user           WSUser
+		queue          []*SongConfig
+		lastSuggestion time.Duration
+		wantCode       string
+		wantRetryAfter int
+	}{
+		{name: "allowed", user: guest},
+		{
+			name:     "queue full",
+			user:     guest,
+			queue:    []*SongConfig{queuedSong("a", "alice", 0, 0), queuedSong("b", "bob", 0, 0), queuedSong("c", "carol", 0, 0)},
+			wantCode: QuotaQueueFull,
+		},
+		{
+			name:     "per user",
+			user:     guest,
+			queue:    []*SongConfig{queuedSong("a", "guest", 0, 0), queuedSong("b", "guest", 0, 0)},
+			wantCode: QuotaPerUser,
+		},
+		{name: "cooldown", user: guest, lastSuggestion: 10 * time.Second, wantCode: QuotaCooldown, wantRetryAfter: 20},
+		{name: "cooldown over", user: guest, lastSuggestion: 30 * time.Second},
+		{
+			name:           "cohost bypasses quotas",
+			user:           WSUser{UserName: "co", UserType: UserTypeCoHost},
+			queue:          []*SongConfig{queuedSong("a", "co", 0, 0), queuedSong("b", "co", 0, 0), queuedSong("c", "co", 0, 0)},
+			lastSuggestion: time.Second,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := newFakeClock()
+			ws := &WSServer{clock: clock}
+			room := newRoomConfig("party", testHost("host"), "secret")
+			room.SuggestionLimits = limits
+			room.SongQueue = test.queue
+			if test.lastSuggestion > 0 {
+				room.lastSuggestion[test.user.UserName] = clock.Now().Add(-test.lastSuggestion)
+			}
+
+			err := ws.checkSuggestionLimits(room, test.user)
+			if test.wantCode == "" {
+				if err != nil {
+					t.Fatalf("suggestion rejected: %v", err)
+				}
+				return
+			}
+			var quotaErr *QuotaError
+			if !errors.As(err, &quotaErr) {
+				t.Fatalf("got %v, want a QuotaError", err)
+			}
+			if quotaErr.Code != test.wantCode || quotaErr.RetryAfterSeconds() != test.wantRetryAfter {
+				t.Errorf("got %s retrying after %ds, want %s after %ds", quotaErr.Code, quotaErr.RetryAfterSeconds(), test.wantCode, test.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestSuggestionCooldownHoldsAcrossInstances(t *testing.T) {
+	store, broker := NewMemoryStore(), NewMemoryBroker()
+	a, srvA := newTestInstance(t, NewRealClock(), store, broker)
+	b, srvB := newTestInstance(t, NewRealClock(), store, broker)
+	if _, err := a.WSServer.addRoom("party", testHost("host"), RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, hostID := joinTestRoom(t, a, srvA, "party", "host")
+	_, guestID := joinTestRoom(t, b, srvB, "party", "guest")
+	if err := a.WSServer.addSuggestedSong(testSong("playing", time.Minute).Track, "party", hostID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.WSServer.addSuggestedSong(testSong("first", time.Minute).Track, "party", guestID); err != nil {
+		t.Fatal(err)
+	}
+	err := a.WSServer.addSuggestedSong(testSong("second", time.Minute).Track, "party", guestID)
+	var quotaErr *QuotaError
+	if !errors.As(err, &quotaErr) || quotaErr.Code != QuotaCooldown {
+		t.Errorf("suggesting through another instance returned %v, want a cooldown", err)
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log"
+	"time"
+)
+
+// reconnectGracePeriod is how long a dropped user keeps their place in a room.
+const reconnectGracePeriod = 60 * time.Second
+
+const (
+	EventUserDisconnected = "user_disconnected"
+	EventUserReconnected  = "user_reconnected"
+)
+
+// roomMember tracks a user's place in a room across connections. Members only exist on the
+// instance they joined through, like connections, so a user resumes on the same instance.
+type roomMember struct {
+	reconnectToken string
+	// graceTimer is set while the member is disconnected; when it fires the member is removed.
+	graceTimer Timer
+}
+
+func (m *roomMember) isDisconnected() bool {
+	return m.graceTimer != nil
+}
+
+func reconnectTokenMatches(member *roomMember, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(member.reconnectToken), []byte(token)) == 1
+}
+
+// listedUser returns the room's entry for userName in ConnectedUserList.
+// It must be called with the mutex held.
+func listedUser(room *RoomConfig, userName string) *WSUser {
+	for _, user := range room.ConnectedUserList {
+		if user.UserName == userName {
+			return user
+		}
+	}
+	return nil
+}
+
+// holdUser marks a user whose connection dropped as not alive and gives them
+// reconnectGracePeriod to rejoin with their reconnect token.
+// It must be called with the mutex held.
+func (ws *WSServer) holdUser(room *RoomConfig, user WSUser) {
+	member, exists := room.members[user.UserName]
+	if !exists {
+		ws.dropUser(room, user)
+		return
+	}
+	user.IsAlive = false
+	if listed := listedUser(room, user.UserName); listed != nil {
+		listed.IsAlive = false
+	}
+
+	roomName, token := room.RoomName, member.reconnectToken
+	if member.graceTimer != nil {
+		// Armed by an earlier attempt at the same write.
+		member.graceTimer.Stop()
+	}
+	member.graceTimer = ws.clock.AfterFunc(reconnectGracePeriod, func() {
+		ws.expireMember(roomName, user.UserName, token)
+	})
+	log.Printf("User %s disconnected from room %s, holding their place", user.UserName, roomName)
+	ws.emit(room, RoomEvent{Event: EventUserDisconnected, Sender: user, User: &user})
+	ws.recountSkipVotes(room, user)
+}
+
+// expireMember removes a user who didn't reconnect in time. token identifies the
+// disconnect that armed the timer, so a timer from before a reconnect does nothing.
+func (ws *WSServer) expireMember(roomName, userName, token string) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	room, roomExists := ws.roomConfigMap[roomName]
+	if !roomExists {
+		return
+	}
+	member, exists := room.members[userName]
+	if !exists || !member.isDisconnected() || member.reconnectToken != token {
+		return
+	}
+	err := ws.updateRoom(roomName, func(room *RoomConfig) error {
+		listed := listedUser(room, userName)
+		if listed == nil {
+			delete(room.members, userName)
+			return nil
+		}
+		log.Printf("User %s didn't reconnect to room %s in time", userName, roomName)
+		ws.dropUser(room, *listed)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Could not remove %s from room %s: %v", userName, roomName, err)
+	}
+}
+
+// stopGraceTimers must be called with the mutex held.
+func (ws *WSServer) stopGraceTimers(room *RoomConfig) {
+	for _, member := range room.members {
+		if member.graceTimer != nil {
+			member.graceTimer.Stop()
+			member.graceTimer = nil
+		}
+	}
+}
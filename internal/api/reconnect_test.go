@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+)
+
+// listedTestUser returns a copy of userName's entry in room party, or nil if they aren't in it.
+func listedTestUser(ws *WSServer, userName string) *WSUser {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if listed := listedUser(ws.roomConfigMap["party"], userName); listed != nil {
+		user := *listed
+		return &user
+	}
+	return nil
+}
+
+func TestReconnectWithinGracePeriod(t *testing.T) {
+	clock := newFakeClock()
+	a, srv := newTestAPI(t, clock)
+	ws := a.WSServer
+	if _, err := ws.addRoom("party", testHost("host"), RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	joinTestRoom(t, a, srv, "party", "host")
+	guest, joined := joinTestRoomWith(t, a, srv, "party", "guest", nil)
+
+	// Closing without a close frame is a dropped connection, not a leave.
+	guest.Close()
+	waitFor(t, "the guest to be held", func() bool {
+		listed := listedTestUser(ws, "guest")
+		return listed != nil && !listed.IsAlive
+	})
+
+	identity := Identity{UserID: guestIDPrefix + "guest", UserName: "guest"}
+	if _, err := ws.joinUser(joinRequest{RoomName: "party", Identity: identity}, nil); err == nil {
+		t.Error("guest got back in without their reconnect token")
+	}
+	if _, err := ws.joinUser(joinRequest{RoomName: "party", Identity: identity, ReconnectToken: "wrong"}, nil); err == nil {
+		t.Error("guest got back in with the wrong reconnect token")
+	}
+
+	_, resumed := joinTestRoomWith(t, a, srv, "party", "guest", url.Values{"reconnectToken": {joined.ReconnectToken}})
+	if resumed.ReconnectToken == "" || resumed.ReconnectToken == joined.ReconnectToken {
+		t.Error("reconnect token wasn't rotated on resume")
+	}
+	if listed := listedTestUser(ws, "guest"); listed == nil || !listed.IsAlive {
+		t.Fatal("guest isn't back in the room")
+	}
+
+	// The grace timer was stopped when the guest came back.
+	clock.Advance(reconnectGracePeriod)
+	if listed := listedTestUser(ws, "guest"); listed == nil || !listed.IsAlive {
+		t.Error("guest was removed after resuming")
+	}
+}
+
+func TestMemberRemovedAfterGracePeriod(t *testing.T) {
+	clock := newFakeClock()
+	a, srv := newTestAPI(t, clock)
+	ws := a.WSServer
+	if _, err := ws.addRoom("party", testHost("host"), RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	joinTestRoom(t, a, srv, "party", "host")
+	guest, _ := joinTestRoom(t, a, srv, "party", "guest")
+
+	guest.Close()
+	waitFor(t, "the guest to be held", func() bool {
+		listed := listedTestUser(ws, "guest")
+		return listed != nil && !listed.IsAlive
+	})
+	clock.Advance(reconnectGracePeriod - 1)
+	if listedTestUser(ws, "guest") == nil {
+		t.Fatal("guest removed before the grace period ended")
+	}
+	clock.Advance(1)
+	if listedTestUser(ws, "guest") != nil {
+		t.Error("guest still in the room after the grace period")
+	}
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"fmt"
+	"log"
+)
+
+// Roles a user can hold in a room, stored in WSUser.UserType.
+const (
+	UserTypeHost      = "host"
+	UserTypeCoHost    = "cohost"
+	UserTypeModerator = "moderator"
+	UserTypeGuest     = "guest"
+	// UserTypeListener can follow the room but not take part in it.
+	UserTypeListener = "listener"
+)
+
+type Permission string
+
+const (
+	PermissionSuggest        Permission = "suggest"
+	PermissionVote           Permission = "vote"
+	PermissionChat           Permission = "chat"
+	PermissionSkip           Permission = "skip"
+	PermissionRemoveSong     Permission = "remove_song"
+	PermissionBypassQuota    Permission = "bypass_quota"
+	PermissionPin            Permission = "pin"
+	PermissionKick           Permission = "kick"
+	PermissionMuteChat       Permission = "mute_chat"
+	PermissionChangeSettings Permission = "change_settings"
+	PermissionManageRoles    Permission = "manage_roles"
+)
+
+// rolePermissions is the permission matrix. Every WSServer mutation checks it through checkPermission.
+var rolePermissions = map[string]map[Permission]bool{
+	UserTypeHost: {
+		PermissionSuggest: true, PermissionVote: true, PermissionChat: true,
+		PermissionSkip: true, PermissionRemoveSong: true, PermissionBypassQuota: true, PermissionPin: true,
+		PermissionKick: true, PermissionMuteChat: true, PermissionChangeSettings: true,
+		PermissionManageRoles: true,
+	},
+	UserTypeCoHost: {
+		PermissionSuggest: true, PermissionVote: true, PermissionChat: true,
+		PermissionSkip: true, PermissionRemoveSong: true, PermissionBypassQuota: true, PermissionPin: true,
+		PermissionKick: true, PermissionMuteChat: true, PermissionChangeSettings: true,
+	},
+	UserTypeModerator: {
+		PermissionSuggest: true, PermissionVote: true, PermissionChat: true,
+		PermissionRemoveSong: true, PermissionPin: true, PermissionKick: true, PermissionMuteChat: true,
+	},
+	UserTypeGuest: {
+		PermissionSuggest: true, PermissionVote: true, PermissionChat: true,
+	},
+	UserTypeListener: {},
+}
+
+// assignableRoles are the roles the host can grant. The host role itself only
+// moves through handoff.
+var assignableRoles = map[string]bool{
+	UserTypeCoHost:    true,
+	UserTypeModerator: true,
+	UserTypeGuest:     true,
+	UserTypeListener:  true,
+}
+
+// PermissionError is returned when a user's role doesn't allow an action.
+type PermissionError struct {
+	Role       string
+	Permission Permission
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("a %s is not allowed to %s", e.Role, e.Permission)
+}
+
+func checkPermission(user WSUser, permission Permission) error {
+	if !rolePermissions[user.UserType][permission] {
+		return &PermissionError{Role: user.UserType, Permission: permission}
+	}
+	return nil
+}
+
+// authorize resolves connectionID to its user and checks they hold permission.
+// It must be called with the mutex held.
+func (ws *WSServer) authorize(room *RoomConfig, connectionID string, permission Permission) (WSUser, error) {
+	user, err := ws.userForConnection(room, connectionID)
+	if err != nil {
+		return WSUser{}, err
+	}
+	if err := checkPermission(user, permission); err != nil {
+		log.Printf("User %s tried to %s in room %s: %v", user.UserName, permission, room.RoomName, err)
+		return WSUser{}, err
+	}
+	return user, nil
+}
+
+// setUserType changes the role of a user in the room, on every connection they hold.
+// It must be called with the mutex held.
+func (ws *WSServer) setUserType(room *RoomConfig, userName, userType string) {
+	if listed := listedUser(room, userName); listed != nil {
+		listed.UserType = userType
+	}
+	for conn, user := range room.Clients {
+		if user.UserName == userName {
+			user.UserType = userType
+			room.Clients[conn] = user
+		}
+	}
+}
+
+// emitRoleChanged must be called with the mutex held.
+func (ws *WSServer) emitRoleChanged(room *RoomConfig, sender, user WSUser) {
+	ws.emit(room, RoomEvent{Event: EventRoleChanged, Sender: sender, User: &user})
+}
+
+// setRole grants role to userName; granting UserTypeGuest revokes whatever role they had.
+// A room has at most one co-host, so naming a new one demotes the previous one.
+func (ws *WSServer) setRole(roomName, connectionID, userName, role string) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	return ws.updateRoom(roomName, func(room *RoomConfig) error {
+		user, err := ws.authorize(room, connectionID, PermissionManageRoles)
+		if err != nil {
+			return err
+		}
+		if !assignableRoles[role] {
+			return fmt.Errorf("role %s can't be granted", role)
+		}
+		target := listedUser(room, userName)
+		if target == nil {
+			return fmt.Errorf("user %s is not in the room", userName)
+		}
+		if target.UserType == UserTypeHost {
+			return fmt.Errorf("the host's role can't be changed")
+		}
+		if target.UserType == role {
+			return nil
+		}
+
+		if role == UserTypeCoHost {
+			for _, listed := range room.ConnectedUserList {
+				if listed.UserType == UserTypeCoHost {
+					ws.setUserType(room, listed.UserName, UserTypeGuest)
+					ws.emitRoleChanged(room, user, *listed)
+				}
+			}
+		}
+		ws.setUserType(room, userName, role)
+		log.Printf("User %s made %s a %s in room %s", user.UserName, userName, role, roomName)
+		ws.emitRoleChanged(room, user, *target)
+		return nil
+	})
+}
+
+// roleRank orders roles so moderation only ever works downwards.
+var roleRank = map[string]int{
+	UserTypeHost:      4,
+	UserTypeCoHost:    3,
+	UserTypeModerator: 2,
+	UserTypeGuest:     1,
+	UserTypeListener:  0,
+}
+
+func outranks(user, target WSUser) bool {
+	return roleRank[user.UserType] > roleRank[target.UserType]
+}
+
+const EventMuteChanged = "mute_changed"
+
+// setMuted mutes or unmutes userName's chat messages.
+func (ws *WSServer) setMuted(roomName, connectionID, userName string, muted bool) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	return ws.updateRoom(roomName, func(room *RoomConfig) error {
+		user, err := ws.authorize(room, connectionID, PermissionMuteChat)
+		if err != nil {
+			return err
+		}
+		target := listedUser(room, userName)
+		if target == nil {
+			return fmt.Errorf("user %s is not in the room", userName)
+		}
+		if !outranks(user, *target) {
+			return fmt.Errorf("a %s can't mute a %s", user.UserType, target.UserType)
+		}
+		if target.IsMuted == muted {
+			return nil
+		}
+
+		target.IsMuted = muted
+		for conn, client := range room.Clients {
+			if client.UserName == userName {
+				client.IsMuted = muted
+				room.Clients[conn] = client
+			}
+		}
+		log.Printf("User %s set muted=%t for %s in room %s", user.UserName, muted, userName, roomName)
+		mutedUser := *target
+		ws.emit(room, RoomEvent{Event: EventMuteChanged, Sender: user, User: &mutedUser})
+		return nil
+	})
+}
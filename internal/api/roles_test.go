@@ -0,0 +1,65 @@
+package api
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckPermission(t *testing.T) {
+	roles := []string{UserTypeHost, UserTypeCoHost, UserTypeModerator, UserTypeGuest, UserTypeListener}
+	tests := []struct {
+		permission Permission
+		// allowed lists the roles that hold permission, in the order of roles.
+		allowed []bool
+	}{
+		{PermissionSuggest, []bool{true, true, true, true, false}},
+		{PermissionVote, []bool{true, true, true, true, false}},
+		{PermissionChat, []bool{true, true, true, true, false}},
+		{PermissionSkip, []bool{true, true, false, false, false}},
+		{PermissionRemoveSong, []bool{true, true, true, false, false}},
+		{PermissionBypassQuota, []bool{true, true, false, false, false}},
+		{PermissionPin, []bool{true, true, true, false, false}},
+		{PermissionKick, []bool{true, true, true, false, false}},
+		{PermissionMuteChat, []bool{true, true, true, false, false}},
+		{PermissionChangeSettings, []bool{true, true, false, false, false}},
+		{PermissionManageRoles, []bool{true, false, false, false, false}},
+	}
+	for _, test := range tests {
+		for i, role := range roles {
+			err := checkPermission(WSUser{UserName: "user", UserType: role}, test.permission)
+			if allowed := err == nil; allowed != test.allowed[i] {
+				t.Errorf("%s allowed to %s: %t, want %t", role, test.permission, allowed, test.allowed[i])
+			}
+			var permissionErr *PermissionError
+			if err != nil && (!errors.As(err, &permissionErr) || permissionErr.Role != role || permissionErr.Permission != test.permission) {
+				t.Errorf("%s refused %s with %v, want a PermissionError", role, test.permission, err)
+			}
+		}
+	}
+
+	if checkPermission(WSUser{UserName: "user", UserType: "admin"}, PermissionChat) == nil {
+		t.Error("unknown role was allowed to chat")
+	}
+}
+
+func TestOutranks(t *testing.T) {
+	tests := []struct {
+		user, target string
+		want         bool
+	}{
+		{UserTypeHost, UserTypeCoHost, true},
+		{UserTypeCoHost, UserTypeModerator, true},
+		{UserTypeModerator, UserTypeGuest, true},
+		{UserTypeGuest, UserTypeListener, true},
+		{UserTypeModerator, UserTypeModerator, false},
+		{UserTypeModerator, UserTypeCoHost, false},
+		{UserTypeCoHost, UserTypeHost, false},
+		{UserTypeListener, UserTypeGuest, false},
+	}
+	for _, test := range tests {
+		got := outranks(WSUser{UserType: test.user}, WSUser{UserType: test.target})
+		if got != test.want {
+			t.Errorf("%s outranks %s = %t, want %t", test.user, test.target, got, test.want)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -26,12 +29,30 @@ func (a *API) CreateRoomHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	options := RoomOptions{
+		HostHandoff:      req.HostHandoff,
+		AccessMode:       req.AccessMode,
+		Password:         req.Password,
+		RemoveBelowScore: req.RemoveBelowScore,
+		SkipThreshold:    req.SkipThreshold,
+		QueueStrategy:    req.QueueStrategy,
+		SuggestionLimits: suggestionLimits(req),
+	}
+	if err := options.validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	// The host is whoever is signed in, never a name from the request.
+	session, _ := SessionFromContext(r.Context())
 	host := WSUser{
-		UserName: req.UserName,
-		UserType: "host",
+		UserName: session.UserName,
+		UserType: UserTypeHost,
 		IsAlive:  true,
+		UserID:   session.UserID,
 	}
-	err := a.WSServer.addRoom(req.RoomName, host)
+	invite, err := a.WSServer.addRoom(req.RoomName, host, options)
 	if err != nil {
 		w.WriteHeader(http.StatusExpectationFailed)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
@@ -40,12 +61,24 @@ func (a *API) CreateRoomHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusCreated)
 	log.Printf("Room created successfully with name: %s", req.RoomName)
-	json.NewEncoder(w).Encode(CreateRoomResponse{Host: host, RoomName: req.RoomName})
+	json.NewEncoder(w).Encode(CreateRoomResponse{Host: host, RoomName: req.RoomName, InviteToken: invite})
 }
 
 func (a *API) JoinRoomHandler(w http.ResponseWriter, r *http.Request) {
 	roomName := r.URL.Query().Get("roomName")
-	userName := r.URL.Query().Get("userName")
+	reconnectToken := r.URL.Query().Get("reconnectToken")
+
+	// Who is joining comes from their session or guest token. Browsers can't set headers
+	// on a WebSocket handshake, so the token travels in the query string.
+	identity, err := a.identify(r.URL.Query().Get("token"))
+	if err != nil {
+		log.Printf("Invalid token joining room %s: %v", roomName, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Sign in or get a guest token to join"})
+		return
+	}
+	userName := identity.UserName
 
 	if !a.WSServer.isRoomPresent(roomName) {
 		log.Printf("Room not found %s", roomName)
@@ -61,18 +94,52 @@ func (a *API) JoinRoomHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, connID, err := a.WSServer.joinUser(roomName, userName, conn)
+	joined, err := a.WSServer.joinUser(joinRequest{
+		RoomName:       roomName,
+		Identity:       *identity,
+		ReconnectToken: reconnectToken,
+		Password:       r.URL.Query().Get("password"),
+		Invite:         r.URL.Query().Get("invite"),
+	}, conn)
 	if err != nil {
 		log.Printf("Failed to join room %s for user %s: %v", roomName, userName, err)
-		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error())
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, closeReason(err.Error()))
 		conn.WriteMessage(websocket.CloseMessage, msg)
 		conn.Close()
 		return
 	}
 
-	log.Printf("User %s joined room %s as %s", user.UserName, roomName, user.UserType)
+	log.Printf("User %s joined room %s as %s", joined.User.UserName, roomName, joined.User.UserType)
+	a.WSServer.sendSnapshot(roomName, conn, joined)
 
-	go a.WSServer.handleClientMessages(roomName, connID, conn)
+	go a.WSServer.handleClientMessages(roomName, joined.ConnectionID, conn)
+}
+
+// CreateInviteHandler issues an invite token for an invite-only room.
+func (a *API) CreateInviteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method Not Allowed, Try using POST"})
+		return
+	}
+
+	var inviteRequest CreateInviteRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&inviteRequest); err != nil || inviteRequest.ExpiresInSeconds < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	ttl := time.Duration(inviteRequest.ExpiresInSeconds) * time.Second
+	invite, expiresAt, err := a.WSServer.createInvite(inviteRequest.RoomName, inviteRequest.ConnectionID, ttl)
+	if err != nil {
+		w.WriteHeader(roomErrorStatus(err))
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(InviteResponse{InviteToken: invite, ExpiresAt: expiresAt})
 }
 
 func (a *API) SuggestSongHandler(w http.ResponseWriter, r *http.Request) {
@@ -90,16 +157,43 @@ func (a *API) SuggestSongHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := a.WSServer.addSuggestedSong(
-		suggestSongRequest.SongName,
+	track, err := a.WSServer.resolveSuggestion(
+		r.Context(),
 		suggestSongRequest.RoomName,
 		suggestSongRequest.ConnectionID,
+		suggestSongRequest.TrackURI,
+		suggestSongRequest.SongName,
 	)
+	if errors.Is(err, errInvalidSuggestion) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusExpectationFailed)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
 		return
 	}
+
+	err = a.WSServer.addSuggestedSong(
+		track,
+		suggestSongRequest.RoomName,
+		suggestSongRequest.ConnectionID,
+	)
+	var quotaErr *QuotaError
+	if errors.As(err, &quotaErr) {
+		if quotaErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(quotaErr.RetryAfterSeconds()))
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error(), Code: quotaErr.Code, RetryAfter: quotaErr.RetryAfterSeconds()})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(roomErrorStatus(err))
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(Response{Message: "Song Suggested successfully"})
 }
@@ -118,10 +212,19 @@ func (a *API) VoteHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
 		return
 	}
+	if voteRequest.Direction == "" {
+		voteRequest.Direction = VoteUp
+	}
+	if !validVoteDirection(voteRequest.Direction) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid direction"})
+		return
+	}
 
-	err := a.WSServer.voteForSong(voteRequest.SongName, voteRequest.RoomName, voteRequest.ConnectionID)
+	songID := songKey(voteRequest.TrackURI, voteRequest.SongName)
+	err := a.WSServer.voteForSong(songID, voteRequest.RoomName, voteRequest.ConnectionID, voteRequest.Direction)
 	if err != nil {
-		w.WriteHeader(http.StatusExpectationFailed)
+		w.WriteHeader(roomErrorStatus(err))
 		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
 		return
 	}
@@ -144,12 +247,195 @@ func (a *API) SkipSongHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := a.WSServer.skipSong(skipSongRequest.SongName, skipSongRequest.RoomName, skipSongRequest.ConnectionID)
+	songID := songKey(skipSongRequest.TrackURI, skipSongRequest.SongName)
+	err := a.WSServer.skipSong(songID, skipSongRequest.RoomName, skipSongRequest.ConnectionID)
 	if err != nil {
-		w.WriteHeader(http.StatusExpectationFailed)
+		w.WriteHeader(roomErrorStatus(err))
 		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
 		return
 	}
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(Response{Message: "Song Skipped successfully"})
 }
+
+// VoteSkipHandler votes to skip the song that is playing.
+func (a *API) VoteSkipHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method Not Allowed, Try using POST"})
+		return
+	}
+
+	var skipSongRequest SkipSongRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&skipSongRequest); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	songID := songKey(skipSongRequest.TrackURI, skipSongRequest.SongName)
+	err := a.WSServer.voteToSkip(songID, skipSongRequest.RoomName, skipSongRequest.ConnectionID)
+	if err != nil {
+		w.WriteHeader(roomErrorStatus(err))
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Response{Message: "Skip vote cast successfully"})
+}
+
+// RemoveSongHandler takes a song out of the queue.
+func (a *API) RemoveSongHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method Not Allowed, Try using POST"})
+		return
+	}
+
+	var removeRequest SkipSongRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&removeRequest); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	songID := songKey(removeRequest.TrackURI, removeRequest.SongName)
+	err := a.WSServer.removeSong(songID, removeRequest.RoomName, removeRequest.ConnectionID)
+	if err != nil {
+		w.WriteHeader(roomErrorStatus(err))
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Message: "Song removed successfully"})
+}
+
+// PinSongHandler pins a song to play next, or unpins it.
+func (a *API) PinSongHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method Not Allowed, Try using POST"})
+		return
+	}
+
+	var pinRequest PinSongRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&pinRequest); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	songID := songKey(pinRequest.TrackURI, pinRequest.SongName)
+	err := a.WSServer.pinSong(songID, pinRequest.RoomName, pinRequest.ConnectionID, pinRequest.Pinned)
+	if err != nil {
+		w.WriteHeader(roomErrorStatus(err))
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Message: "Queue updated successfully"})
+}
+
+// LockQueueHandler locks the queue to a manual order, or unlocks it.
+func (a *API) LockQueueHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method Not Allowed, Try using POST"})
+		return
+	}
+
+	var lockRequest LockQueueRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&lockRequest); err != nil || lockRequest.DurationSeconds < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	duration := time.Duration(lockRequest.DurationSeconds) * time.Second
+	err := a.WSServer.lockQueue(lockRequest.RoomName, lockRequest.ConnectionID, lockRequest.Order, duration)
+	if err != nil {
+		w.WriteHeader(roomErrorStatus(err))
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Message: "Queue updated successfully"})
+}
+
+func (a *API) KickUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method Not Allowed, Try using POST"})
+		return
+	}
+
+	var kickRequest KickUserRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&kickRequest); err != nil || kickRequest.UserName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	err := a.WSServer.kickUser(kickRequest.RoomName, kickRequest.ConnectionID, kickRequest.UserName, kickRequest.Reason, kickRequest.Ban)
+	if err != nil {
+		w.WriteHeader(roomErrorStatus(err))
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Message: "User removed successfully"})
+}
+
+func (a *API) UnbanUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method Not Allowed, Try using POST"})
+		return
+	}
+
+	var unbanRequest UnbanUserRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&unbanRequest); err != nil || unbanRequest.UserName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	err := a.WSServer.unbanUser(unbanRequest.RoomName, unbanRequest.ConnectionID, unbanRequest.UserName)
+	if err != nil {
+		w.WriteHeader(roomErrorStatus(err))
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Message: "Ban lifted successfully"})
+}
+
+// suggestionLimits overrides the default limits with those given in req.
+func suggestionLimits(req CreateRoomRequest) *SuggestionLimits {
+	limits := DefaultSuggestionLimits
+	if req.MaxQueuedPerUser != nil {
+		limits.MaxQueuedPerUser = *req.MaxQueuedPerUser
+	}
+	if req.SuggestionCooldownSeconds != nil {
+		limits.Cooldown = time.Duration(*req.SuggestionCooldownSeconds) * time.Second
+	}
+	if req.MaxQueueLength != nil {
+		limits.MaxQueueLength = *req.MaxQueueLength
+	}
+	return &limits
+}
+
+// roomErrorStatus maps an error from a room action to its HTTP status.
+func roomErrorStatus(err error) int {
+	var permissionErr *PermissionError
+	if errors.As(err, &permissionErr) {
+		return http.StatusForbidden
+	}
+	return http.StatusExpectationFailed
+}
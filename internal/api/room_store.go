@@ -0,0 +1,318 @@
+package api
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	roomKeyPrefix     = "room:"
+	instanceKeyPrefix = "instance:"
+	playbackKeyPrefix = "playback:"
+	// Kept apart from roomKeyPrefix so LoadRooms doesn't take the counters for rooms.
+	seqKeyPrefix = "room-seq:"
+)
+
+// instanceTTL is how long an instance counts as live after it last announced itself.
+const instanceTTL = 30 * time.Second
+
+// errRoomChanged is returned when another instance stored a room since it was read.
+var errRoomChanged = errors.New("room was changed by another instance")
+
+// storedUser keeps the parts of a WSUser that aren't sent to clients.
+type storedUser struct {
+	WSUser
+	UserID   string `json:"userId"`
+	Instance string `json:"instance"`
+	// ConnectionID lets any instance tell whose connection a request comes from.
+	ConnectionID string `json:"connectionId"`
+}
+
+// storedRoom is the durable part of a RoomConfig. Connections are per-process
+// and are rebuilt as clients rejoin, so they are not persisted.
+type storedRoom struct {
+	// Version counts the writes to the room, so no two writes store the same JSON.
+	Version uint64 `json:"version"`
+	Host    WSUser `json:"host"`
+	Owner   WSUser `json:"owner"`
+	// HostID and OwnerID keep the identities that WSUser doesn't serialize.
+	HostID      string `json:"hostId"`
+	OwnerID     string `json:"ownerId"`
+	HostHandoff string `json:"hostHandoff"`
+	AccessMode  string `json:"accessMode"`
+	// PasswordHash is a bcrypt hash, never the password itself.
+	PasswordHash string        `json:"passwordHash,omitempty"`
+	RoomName     string        `json:"roomName"`
+	SongQueue    []*SongConfig `json:"songQueue"`
+	CurrentSong  *SongConfig   `json:"currentSong"`
+	// SongStartedAt lets a restored room resume its playback clock mid-song.
+	SongStartedAt time.Time `json:"songStartedAt"`
+	Secret        string    `json:"secret"`
+	// Seq is kept so event numbering carries on across instances and restarts.
+	Seq  uint64    `json:"seq"`
+	Bans []RoomBan `json:"bans"`
+	// RemoveBelowScore is nil for rooms stored before downvotes existed.
+	RemoveBelowScore *int `json:"removeBelowScore"`
+	// SkipThreshold is zero for rooms stored before vote-to-skip existed.
+	SkipThreshold float64   `json:"skipThreshold"`
+	SkipVotes     []*WSUser `json:"skipVotes"`
+	QueueStrategy string    `json:"queueStrategy"`
+	// SuggestionLimits is nil for rooms stored before limits existed.
+	SuggestionLimits *SuggestionLimits `json:"suggestionLimits"`
+	// LastSuggestion is shared so the cooldown holds on every instance.
+	LastSuggestion map[string]time.Time `json:"lastSuggestion"`
+	PinnedOrder    []string             `json:"pinnedOrder"`
+	LockedOrder    []string             `json:"lockedOrder"`
+	LockedUntil    time.Time            `json:"lockedUntil"`
+	// IsHostPresent and Users are shared so every instance sees who is in the room.
+	IsHostPresent bool         `json:"isHostPresent"`
+	Users         []storedUser `json:"users"`
+	StandInRole   string       `json:"standInRole,omitempty"`
+}
+
+// RoomStore persists rooms and their song queues so they survive a restart.
+type RoomStore struct {
+	store Store
+}
+
+func NewRoomStore(store Store) *RoomStore {
+	return &RoomStore{store: store}
+}
+
+func roomKey(roomName string) string {
+	return roomKeyPrefix + roomName
+}
+
+func instanceKey(instanceID string) string {
+	return instanceKeyPrefix + instanceID
+}
+
+func playbackKey(roomName string) string {
+	return playbackKeyPrefix + roomName
+}
+
+func seqKey(roomName string) string {
+	return seqKeyPrefix + roomName
+}
+
+// MarkInstanceLive records that instanceID is running, so the users connected
+// through it are kept when other instances load its rooms.
+func (rs *RoomStore) MarkInstanceLive(instanceID string) error {
+	return rs.store.Set(instanceKey(instanceID), "live", instanceTTL)
+}
+
+func (rs *RoomStore) SaveRoom(room *RoomConfig) error {
+	users := []storedUser{}
+	for _, user := range room.ConnectedUserList {
+		users = append(users, storedUser{WSUser: *user, UserID: user.UserID, Instance: user.Instance, ConnectionID: user.ConnectionID})
+	}
+	data, err := json.Marshal(storedRoom{
+		Version:          room.Version + 1,
+		Host:             room.Host,
+		Owner:            room.Owner,
+		HostID:           room.Host.UserID,
+		OwnerID:          room.Owner.UserID,
+		HostHandoff:      room.HostHandoff,
+		AccessMode:       room.AccessMode,
+		PasswordHash:     room.PasswordHash,
+		RemoveBelowScore: &room.RemoveBelowScore,
+		SkipThreshold:    room.SkipThreshold,
+		SkipVotes:        room.SkipVotes,
+		QueueStrategy:    room.QueueStrategy,
+		SuggestionLimits: &room.SuggestionLimits,
+		LastSuggestion:   room.lastSuggestion,
+		PinnedOrder:      room.PinnedOrder,
+		LockedOrder:      room.LockedOrder,
+		LockedUntil:      room.LockedUntil,
+		RoomName:         room.RoomName,
+		SongQueue:        room.SongQueue,
+		CurrentSong:      room.CurrentSong,
+		SongStartedAt:    room.SongStartedAt,
+		Secret:           room.Secret,
+		Seq:              room.Seq,
+		Bans:             room.Bans,
+		IsHostPresent:    room.IsHostPresent,
+		Users:            users,
+		StandInRole:      room.StandInRole,
+	})
+	if err != nil {
+		return err
+	}
+	// Only stored over the state the room was read from, so no other write is lost.
+	stored, err := rs.store.CompareAndSet(roomKey(room.RoomName), room.stored, string(data))
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return errRoomChanged
+	}
+	room.Version++
+	room.stored = string(data)
+	return nil
+}
+
+// DeleteRoom deletes the room and its playback claim and event counter, unless another
+// instance stored the room since it was read.
+func (rs *RoomStore) DeleteRoom(room *RoomConfig) error {
+	deleted, err := rs.store.CompareAndSet(roomKey(room.RoomName), room.stored, "")
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errRoomChanged
+	}
+	room.stored = ""
+	for _, key := range []string{playbackKey(room.RoomName), seqKey(room.RoomName)} {
+		if err := rs.store.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NextSeq allocates the room's next event sequence number. Numbers come from a counter
+// shared by every instance, so no two events get the same one. last is the latest
+// number this instance knows of, which the counter is moved past if it is behind,
+// as it is for rooms stored before the counter existed.
+func (rs *RoomStore) NextSeq(roomName string, last uint64) (uint64, error) {
+	// One step, so a counter that is behind can't be raised over another instance's number.
+	seq, err := rs.store.Incr(seqKey(roomName), int64(last))
+	if err != nil {
+		return 0, err
+	}
+	return uint64(seq), nil
+}
+
+// ClaimPlayback makes instanceID the one instance that runs the room's playback clock,
+// unless another instance holds the claim. The claim lapses instanceTTL after it was
+// last renewed, so a stopped instance hands playback on.
+func (rs *RoomStore) ClaimPlayback(roomName, instanceID string) (bool, error) {
+	return rs.store.Claim(playbackKey(roomName), instanceID, instanceTTL)
+}
+
+// LoadRooms returns every stored room with its song queue rebuilt into a valid heap.
+// Rooms that can't be decoded are logged and skipped.
+func (rs *RoomStore) LoadRooms() ([]*RoomConfig, error) {
+	keys, err := rs.store.Keys(roomKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := []*RoomConfig{}
+	for _, key := range keys {
+		room, err := rs.LoadRoom(strings.TrimPrefix(key, roomKeyPrefix))
+		if err != nil {
+			log.Printf("Skipping stored room %s: %v", key, err)
+			continue
+		}
+		if room != nil {
+			rooms = append(rooms, room)
+		}
+	}
+	return rooms, nil
+}
+
+// LoadRoom returns the stored room, or nil if it doesn't exist.
+func (rs *RoomStore) LoadRoom(roomName string) (*RoomConfig, error) {
+	data, exists, err := rs.store.Get(roomKey(roomName))
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	var stored storedRoom
+	if err := json.Unmarshal([]byte(data), &stored); err != nil {
+		return nil, err
+	}
+
+	stored.Host.UserID = stored.HostID
+	stored.Owner.UserID = stored.OwnerID
+	room := newRoomConfig(stored.RoomName, stored.Host, stored.Secret)
+	// Rooms stored before handoff existed are owned by their host.
+	if stored.Owner.UserName != "" {
+		room.Owner = stored.Owner
+	}
+	if stored.HostHandoff != "" {
+		room.HostHandoff = stored.HostHandoff
+	}
+	if stored.AccessMode != "" {
+		room.AccessMode = stored.AccessMode
+	}
+	room.PasswordHash = stored.PasswordHash
+	if stored.RemoveBelowScore != nil {
+		room.RemoveBelowScore = *stored.RemoveBelowScore
+	}
+	if stored.SkipThreshold != 0 {
+		room.SkipThreshold = stored.SkipThreshold
+	}
+	room.SkipVotes = stored.SkipVotes
+	if stored.QueueStrategy != "" {
+		room.QueueStrategy = stored.QueueStrategy
+	}
+	if stored.SuggestionLimits != nil {
+		room.SuggestionLimits = *stored.SuggestionLimits
+	}
+	if stored.LastSuggestion != nil {
+		room.lastSuggestion = stored.LastSuggestion
+	}
+	room.PinnedOrder = stored.PinnedOrder
+	room.LockedOrder = stored.LockedOrder
+	room.LockedUntil = stored.LockedUntil
+	room.CurrentSong = stored.CurrentSong
+	room.SongStartedAt = stored.SongStartedAt
+	room.Seq = stored.Seq
+	room.Bans = stored.Bans
+	room.ConnectedUserList = rs.liveUsers(stored.Users)
+	room.IsHostPresent = stored.IsHostPresent && listedUser(room, room.Host.UserName) != nil
+	room.StandInRole = stored.StandInRole
+	room.Version = stored.Version
+	room.stored = data
+	for _, song := range append([]*SongConfig{room.CurrentSong}, stored.SongQueue...) {
+		// Scores are derived from the votes, which older rooms stored without.
+		if song != nil {
+			song.Score = len(song.Votes) - len(song.Downvotes)
+			if stored.QueueStrategy == "" {
+				song.Priority = float64(song.Score)
+			}
+		}
+	}
+	if room.CurrentSong != nil && room.CurrentSong.SongID == "" {
+		room.CurrentSong.SongID = songKey("", room.CurrentSong.SongName)
+	}
+	for i, song := range stored.SongQueue {
+		// Rooms stored before songs carried an ID are matched by name.
+		if song.SongID == "" {
+			song.SongID = songKey("", song.SongName)
+		}
+		song.Index = i
+		room.SongQueue = append(room.SongQueue, song)
+	}
+	heap.Init(&room.SongQueue)
+	return room, nil
+}
+
+// liveUsers returns the stored users whose instance is still running. Users of an
+// instance that stopped lost their connection with it and will have to rejoin.
+func (rs *RoomStore) liveUsers(stored []storedUser) []*WSUser {
+	live := make(map[string]bool)
+	users := []*WSUser{}
+	for _, s := range stored {
+		if _, checked := live[s.Instance]; !checked {
+			// Users are kept when the store can't tell, rather than dropped by an outage.
+			_, exists, err := rs.store.Get(instanceKey(s.Instance))
+			live[s.Instance] = exists || err != nil
+		}
+		if !live[s.Instance] {
+			continue
+		}
+		user := s.WSUser
+		user.UserID = s.UserID
+		user.Instance = s.Instance
+		user.ConnectionID = s.ConnectionID
+		users = append(users, &user)
+	}
+	return users
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"container/heap"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoomStoreRoundTrip(t *testing.T) {
+	clock := newFakeClock()
+	ws := NewWSServer(NewRoomStore(NewMemoryStore()), NewMemoryBroker(), nil, nil, clock)
+	alice, bob := &WSUser{UserName: "alice"}, &WSUser{UserName: "bob"}
+	song := func(id string, votes, downvotes []*WSUser) *SongConfig {
+		s := testSong(id, time.Minute)
+		s.Votes, s.Downvotes = votes, downvotes
+		s.Score = len(votes) - len(downvotes)
+		s.SuggestedTimestamp = clock.Now()
+		return s
+	}
+
+	room := newRoomConfig("party", testHost("host"), "secret")
+	room.CurrentSong = song("playing", []*WSUser{alice}, nil)
+	room.SongStartedAt = clock.Now()
+	for _, s := range []*SongConfig{
+		song("low", []*WSUser{alice}, []*WSUser{bob}),
+		song("high", []*WSUser{alice, bob}, nil),
+		song("pinned", nil, []*WSUser{bob}),
+		song("mid", []*WSUser{bob}, nil),
+	} {
+		heap.Push(&room.SongQueue, s)
+	}
+	room.PinnedOrder = []string{"spotify:track:pinned"}
+	ws.reorderQueue(room)
+	if err := ws.roomStore.SaveRoom(room); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := ws.roomStore.LoadRoom("party")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.CurrentSong == nil || loaded.CurrentSong.SongID != "spotify:track:playing" || !loaded.SongStartedAt.Equal(room.SongStartedAt) {
+		t.Errorf("current song = %+v started at %s, want playing started at %s", loaded.CurrentSong, loaded.SongStartedAt, room.SongStartedAt)
+	}
+	if !reflect.DeepEqual(loaded.PinnedOrder, room.PinnedOrder) {
+		t.Errorf("pinned order = %v, want %v", loaded.PinnedOrder, room.PinnedOrder)
+	}
+
+	queue := loaded.SongQueue
+	for i := range queue {
+		if queue[i].Index != i {
+			t.Errorf("song %s at %d has index %d", queue[i].SongID, i, queue[i].Index)
+		}
+		if parent := (i - 1) / 2; i > 0 && queue.Less(i, parent) {
+			t.Errorf("song %s at %d sorts before its parent %s", queue[i].SongID, i, queue[parent].SongID)
+		}
+	}
+	got, scores := []string{}, []int{}
+	for queue.Len() > 0 {
+		s := heap.Pop(&queue).(*SongConfig)
+		got = append(got, s.SongName)
+		scores = append(scores, s.Score)
+	}
+	if want := []string{"pinned", "high", "mid", "low"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pop order = %v, want %v", got, want)
+	}
+	if want := []int{-1, 2, 1, 0}; !reflect.DeepEqual(scores, want) {
+		t.Errorf("scores = %v, want %v", scores, want)
+	}
+}
+
+func TestNextSeqCatchesUpWithTheRoom(t *testing.T) {
+	roomStore := NewRoomStore(NewMemoryStore())
+	for _, step := range []struct {
+		last uint64
+		want uint64
+	}{
+		// The counter starts behind a room stored before it existed.
+		{last: 41, want: 42},
+		{last: 42, want: 43},
+		// An instance that missed events still gets a fresh number.
+		{last: 10, want: 44},
+	} {
+		got, err := roomStore.NextSeq("party", step.last)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != step.want {
+			t.Errorf("NextSeq after %d = %d, want %d", step.last, got, step.want)
+		}
+	}
+}
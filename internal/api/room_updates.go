@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// maxRoomWriteAttempts bounds how often updateRoom retries a change that keeps losing
+// out to writes from other instances.
+const maxRoomWriteAttempts = 5
+
+// roomWrite is a change to a room in progress. What the change does outside the room
+// waits here until the room is stored, so a change that has to be retried does it once.
+type roomWrite struct {
+	room *RoomConfig
+	// changed is set for changes that don't emit an event but still need storing.
+	changed bool
+	events  []RoomEvent
+	after   []func()
+	// deleted is set when the change deletes the room.
+	deleted bool
+}
+
+// updateRoom applies change to the latest stored state of the room and stores the
+// result. If another instance stored the room in the meantime, change runs again on top
+// of that instance's write, so neither write is lost. change may return an error after
+// emitting events; what it did is stored all the same.
+// It must be called with the mutex held.
+func (ws *WSServer) updateRoom(roomName string, change func(room *RoomConfig) error) error {
+	for attempt := 1; ; attempt++ {
+		ws.refreshRoom(roomName)
+		room, roomExists := ws.getRoom(roomName)
+		if !roomExists {
+			log.Printf("Room %s not present", roomName)
+			return fmt.Errorf("room %s not present", roomName)
+		}
+
+		ws.write = &roomWrite{room: room}
+		err := change(room)
+		write := ws.write
+		ws.write = nil
+		if !write.changed && !write.deleted && len(write.events) == 0 {
+			return err
+		}
+		if ws.commit(write) {
+			return err
+		}
+		if attempt == maxRoomWriteAttempts {
+			log.Printf("Gave up writing room %s after %d conflicting writes", roomName, attempt)
+			// Drops what the change did to the local copy of the room.
+			ws.refreshRoom(roomName)
+			return fmt.Errorf("room %s is busy, please try again", roomName)
+		}
+		log.Printf("Room %s was changed by another instance, retrying", roomName)
+	}
+}
+
+// commit stores a change to a room, then broadcasts its events and runs the work it put
+// off. It reports false if another instance stored the room first, in which case none
+// of that happens.
+// It must be called with the mutex held.
+func (ws *WSServer) commit(write *roomWrite) bool {
+	room := write.room
+	if write.deleted {
+		return ws.commitDelete(room)
+	}
+
+	// Numbered before the room is stored, so every instance that loads it knows of them.
+	events := make([]RoomEvent, 0, len(write.events))
+	for _, event := range write.events {
+		event.Type = FrameEvent
+		event.RoomName = room.RoomName
+		event.Seq = ws.nextSeq(room)
+		events = append(events, event)
+	}
+	err := ws.roomStore.SaveRoom(room)
+	if errors.Is(err, errRoomChanged) {
+		return false
+	}
+	if err != nil {
+		// Logged rather than returned so a Redis outage doesn't stop the party.
+		log.Printf("Could not persist room %s: %v", room.RoomName, err)
+	}
+	for _, event := range events {
+		ws.broadcast(room.RoomName, event)
+	}
+	for _, run := range write.after {
+		run()
+	}
+	return true
+}
+
+// nextSeq numbers the room's next event.
+// It must be called with the mutex held.
+func (ws *WSServer) nextSeq(room *RoomConfig) uint64 {
+	seq, err := ws.roomStore.NextSeq(room.RoomName, room.Seq)
+	if err != nil {
+		log.Printf("Could not allocate a sequence number in room %s: %v", room.RoomName, err)
+		seq = room.Seq + 1
+	}
+	room.Seq = seq
+	return seq
+}
+
+// markChanged has the current write store the room even if it emits no event.
+// It must be called with the mutex held.
+func (ws *WSServer) markChanged(room *RoomConfig) {
+	if ws.write != nil && ws.write.room == room {
+		ws.write.changed = true
+		return
+	}
+	ws.persistRoom(room)
+}
+
+// deleting reports whether the current write deletes room.
+// It must be called with the mutex held.
+func (ws *WSServer) deleting(room *RoomConfig) bool {
+	return ws.write != nil && ws.write.room == room && ws.write.deleted
+}
+
+// afterWrite runs fn once the current write to room is stored, or straight away outside one.
+// It must be called with the mutex held.
+func (ws *WSServer) afterWrite(room *RoomConfig, fn func()) {
+	if ws.write != nil && ws.write.room == room {
+		ws.write.after = append(ws.write.after, fn)
+		return
+	}
+	fn()
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVotesOnTwoInstancesAreAllKept(t *testing.T) {
+	store, broker := NewMemoryStore(), NewMemoryBroker()
+	a, srvA := newTestInstance(t, NewRealClock(), store, broker)
+	b, srvB := newTestInstance(t, NewRealClock(), store, broker)
+	if _, err := a.WSServer.addRoom("party", testHost("host"), RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, hostID := joinTestRoom(t, a, srvA, "party", "host")
+	_, guestID := joinTestRoom(t, b, srvB, "party", "guest")
+
+	playing, queued := testSong("playing", time.Minute), testSong("queued", time.Minute)
+	if err := a.WSServer.addSuggestedSong(playing.Track, "party", hostID); err != nil {
+		t.Fatal(err)
+	}
+	// Suggested through the instance the guest isn't connected to.
+	if err := a.WSServer.addSuggestedSong(queued.Track, "party", guestID); err != nil {
+		t.Fatalf("guest couldn't suggest through another instance: %v", err)
+	}
+
+	// Voters join both instances, and each votes through the other one, all at once.
+	instances := []*API{a, b}
+	servers := []*httptest.Server{srvA, srvB}
+	var wg sync.WaitGroup
+	voters := []string{"ann", "bob", "cat", "dan", "eve", "fay"}
+	for i, name := range voters {
+		_, connID := joinTestRoom(t, instances[i%2], servers[i%2], "party", name)
+		wg.Add(1)
+		go func(other *API, connID string) {
+			defer wg.Done()
+			if err := other.WSServer.voteForSong(queued.SongID, "party", connID, VoteUp); err != nil {
+				t.Errorf("vote failed: %v", err)
+			}
+		}(instances[(i+1)%2], connID)
+	}
+	wg.Wait()
+
+	room, err := NewRoomStore(store).LoadRoom("party")
+	if err != nil {
+		t.Fatal(err)
+	}
+	song := room.SongQueue.find(queued.SongID)
+	if song == nil {
+		t.Fatal("queued song is gone")
+	}
+	if want := 1 + len(voters); song.Score != want {
+		t.Errorf("score = %d, want %d", song.Score, want)
+	}
+}
+
+// racingStore has another instance write the room just before this one stores it.
+type racingStore struct {
+	Store
+	mutex sync.Mutex
+	race  func()
+}
+
+func (s *racingStore) CompareAndSet(key, old, value string) (bool, error) {
+	s.mutex.Lock()
+	race := s.race
+	s.race = nil
+	s.mutex.Unlock()
+	if race != nil {
+		race()
+	}
+	return s.Store.CompareAndSet(key, old, value)
+}
+
+func TestConflictingRoomWriteIsRetried(t *testing.T) {
+	store, broker := NewMemoryStore(), NewMemoryBroker()
+	racing := &racingStore{Store: store}
+	a, srvA := newTestInstance(t, NewRealClock(), racing, broker)
+	b, srvB := newTestInstance(t, NewRealClock(), store, broker)
+	if _, err := a.WSServer.addRoom("party", testHost("host"), RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, hostID := joinTestRoom(t, a, srvA, "party", "host")
+	_, guestID := joinTestRoom(t, b, srvB, "party", "guest")
+	for _, song := range []*SongConfig{testSong("playing", time.Minute), testSong("first", time.Minute), testSong("second", time.Minute)} {
+		if err := a.WSServer.addSuggestedSong(song.Track, "party", hostID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	racing.mutex.Lock()
+	racing.race = func() {
+		if err := b.WSServer.voteForSong("spotify:track:first", "party", guestID, VoteUp); err != nil {
+			t.Errorf("guest couldn't vote: %v", err)
+		}
+	}
+	racing.mutex.Unlock()
+	if err := a.WSServer.voteForSong("spotify:track:second", "party", guestID, VoteUp); err != nil {
+		t.Fatalf("vote wasn't retried: %v", err)
+	}
+
+	room, err := NewRoomStore(store).LoadRoom("party")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, songID := range []string{"spotify:track:first", "spotify:track:second"} {
+		if song := room.SongQueue.find(songID); song == nil || song.Score != 2 {
+			t.Errorf("song %s lost the guest's vote", songID)
+		}
+	}
+}
+
+func TestStaleRoomWriteIsRejected(t *testing.T) {
+	roomStore := NewRoomStore(NewMemoryStore())
+	room := newRoomConfig("party", testHost("host"), "secret")
+	if err := roomStore.SaveRoom(room); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := roomStore.LoadRoom("party")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room.Bans = append(room.Bans, RoomBan{UserName: "troll"})
+	if err := roomStore.SaveRoom(room); err != nil {
+		t.Fatal(err)
+	}
+	if err := roomStore.SaveRoom(stale); err != errRoomChanged {
+		t.Errorf("stale write returned %v, want errRoomChanged", err)
+	}
+	if err := roomStore.DeleteRoom(stale); err != errRoomChanged {
+		t.Errorf("stale delete returned %v, want errRoomChanged", err)
+	}
+
+	stored, err := roomStore.LoadRoom("party")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Version != 2 || len(stored.Bans) != 1 {
+		t.Errorf("stored version %d with %d bans, want version 2 with 1 ban", stored.Version, len(stored.Bans))
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"woahtify-backend/utils"
+)
+
+const (
+	sessionKeyPrefix = "session:"
+	sessionLifetime  = 24 * time.Hour
+)
+
+// Session is the server-side state behind a session JWT. The JWT only carries the session ID;
+// the user's Spotify tokens stay on the server in the TokenManager.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	UserName  string    `json:"userName"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// SessionStore keeps login sessions in Redis, or in memory when Redis isn't available.
+type SessionStore struct {
+	store Store
+}
+
+func NewSessionStore(store Store) *SessionStore {
+	return &SessionStore{store: store}
+}
+
+func sessionKey(sessionID string) string {
+	return sessionKeyPrefix + sessionID
+}
+
+// Create starts a new session for the Spotify user.
+func (ss *SessionStore) Create(userID, userName string) (*Session, error) {
+	sessionID, err := utils.GenerateSecureRandomString(32)
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		ID:        sessionID,
+		UserID:    userID,
+		UserName:  userName,
+		ExpiresAt: time.Now().Add(sessionLifetime),
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	if err := ss.store.Set(sessionKey(sessionID), string(data), sessionLifetime); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Get returns the session, or an error if it doesn't exist or has expired.
+func (ss *SessionStore) Get(sessionID string) (*Session, error) {
+	data, exists, err := ss.store.Get(sessionKey(sessionID))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("session not found")
+	}
+	var session Session
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, err
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, fmt.Errorf("session expired")
+	}
+	return &session, nil
+}
+
+func (ss *SessionStore) Delete(sessionID string) error {
+	return ss.store.Delete(sessionKey(sessionID))
+}
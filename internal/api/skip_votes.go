@@ -0,0 +1,94 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// defaultSkipThreshold skips a song once half the room has voted to.
+const defaultSkipThreshold = 0.5
+
+const EventSkipVote = "skip_vote"
+
+// SkipTally is how close the current song is to being voted off.
+type SkipTally struct {
+	Votes  int `json:"votes"`
+	Needed int `json:"needed"`
+	// Live is the number of connected users who can vote.
+	Live int `json:"live"`
+}
+
+func validSkipThreshold(threshold float64) bool {
+	return threshold > 0 && threshold <= 1
+}
+
+// skipTally counts the skip votes of users who are still connected.
+// It must be called with the mutex held.
+func skipTally(room *RoomConfig) SkipTally {
+	tally := SkipTally{}
+	for _, user := range room.ConnectedUserList {
+		if !user.IsAlive || checkPermission(*user, PermissionVote) != nil {
+			continue
+		}
+		tally.Live++
+		if hasVoted(room.SkipVotes, *user) {
+			tally.Votes++
+		}
+	}
+	tally.Needed = int(math.Ceil(room.SkipThreshold * float64(tally.Live)))
+	if tally.Needed < 1 {
+		tally.Needed = 1
+	}
+	return tally
+}
+
+// voteToSkip records a vote to skip the current song, and skips it once the share of
+// live users voting to skip reaches the room's threshold.
+func (ws *WSServer) voteToSkip(songID, roomName, connectionID string) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	return ws.updateRoom(roomName, func(room *RoomConfig) error {
+		user, err := ws.authorize(room, connectionID, PermissionVote)
+		if err != nil {
+			return err
+		}
+		if room.CurrentSong == nil || !room.CurrentSong.matches(songID) {
+			return fmt.Errorf("can't vote to skip a song that is not playing")
+		}
+		if hasVoted(room.SkipVotes, user) {
+			return fmt.Errorf("user %s has already voted to skip %s", user.UserName, room.CurrentSong.SongName)
+		}
+
+		room.SkipVotes = append(room.SkipVotes, &user)
+		ws.countSkipVotes(room, user)
+		return nil
+	})
+}
+
+// countSkipVotes broadcasts the current song's skip tally, and skips the song if the
+// tally has reached the threshold.
+// It must be called with the mutex held.
+func (ws *WSServer) countSkipVotes(room *RoomConfig, sender WSUser) {
+	tally := skipTally(room)
+	skipped := room.CurrentSong
+	ws.emit(room, RoomEvent{Event: EventSkipVote, Sender: sender, Song: skipped, SkipTally: &tally})
+	if tally.Votes < tally.Needed {
+		return
+	}
+
+	log.Printf("Room %s voted to skip %s (%d of %d)", room.RoomName, skipped.SongName, tally.Votes, tally.Live)
+	ws.advanceSong(room)
+	ws.emitNowPlaying(room, sender)
+}
+
+// recountSkipVotes recounts the skip vote after user stops being live in the room. With
+// fewer live users, the votes already cast may now be enough to skip.
+// It must be called with the mutex held.
+func (ws *WSServer) recountSkipVotes(room *RoomConfig, user WSUser) {
+	if ws.roomConfigMap[room.RoomName] != room || ws.deleting(room) || room.CurrentSong == nil || len(room.SkipVotes) == 0 {
+		return
+	}
+	ws.countSkipVotes(room, user)
+}
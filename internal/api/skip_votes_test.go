@@ -0,0 +1,38 @@
+package api
+
+import (
+	"container/heap"
+	"testing"
+	"time"
+)
+
+func TestSkipVoteRecountedWhenUserDisconnects(t *testing.T) {
+	a, srv := newTestAPI(t, newFakeClock())
+	ws := a.WSServer
+	if _, err := ws.addRoom("party", testHost("host"), RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, hostID := joinTestRoom(t, a, srv, "party", "host")
+	joinTestRoom(t, a, srv, "party", "first")
+	second, _ := joinTestRoom(t, a, srv, "party", "second")
+
+	playing, next := testSong("playing", time.Minute), testSong("next", time.Minute)
+	ws.mutex.Lock()
+	room := ws.roomConfigMap["party"]
+	heap.Push(&room.SongQueue, next)
+	ws.startSong(room, playing)
+	ws.persistRoom(room)
+	ws.mutex.Unlock()
+
+	// One of three live users is short of the two votes needed.
+	if err := ws.voteToSkip(playing.SongID, "party", hostID); err != nil {
+		t.Fatal(err)
+	}
+	if got := currentSongID(ws); got != playing.SongID {
+		t.Fatalf("current song = %q after one vote, want %q", got, playing.SongID)
+	}
+
+	// With two users live, the host's vote is enough.
+	second.Close()
+	waitFor(t, "the song to be skipped", func() bool { return currentSongID(ws) == next.SongID })
+}
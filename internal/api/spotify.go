@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+)
+
+// newSpotifyClient builds a Spotify Web API client for the given token.
+// SpotifyBaseURL lets tests point the client at a fake Spotify server.
+func (a *API) newSpotifyClient(ctx context.Context, token *oauth2.Token) *spotify.Client {
+	opts := []spotify.ClientOption{}
+	if a.SpotifyBaseURL != "" {
+		opts = append(opts, spotify.WithBaseURL(a.SpotifyBaseURL))
+	}
+	return spotify.New(a.SpotifyAuthenticator.Client(ctx, token), opts...)
+}
+
+// hostSpotifyClient returns a Spotify client acting on behalf of the room's host.
+// Hosts who joined as guests have no Spotify account to act for.
+func (a *API) hostSpotifyClient(ctx context.Context, host WSUser) (*spotify.Client, error) {
+	token, err := a.Tokens.Token(ctx, host.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return a.newSpotifyClient(ctx, token), nil
+}
+
+func trackFromSpotify(t *spotify.FullTrack) Track {
+	artists := make([]string, 0, len(t.Artists))
+	for _, artist := range t.Artists {
+		artists = append(artists, artist.Name)
+	}
+	imageURL := ""
+	if len(t.Album.Images) > 0 {
+		// Spotify lists album art widest first.
+		imageURL = t.Album.Images[0].URL
+	}
+	return Track{
+		ID:         string(t.ID),
+		URI:        string(t.URI),
+		Title:      t.Name,
+		Artists:    artists,
+		Album:      t.Album.Name,
+		DurationMs: int(t.Duration),
+		ImageURL:   imageURL,
+	}
+}
+
+// LookupTrack resolves a spotify:track URI to its canonical metadata using the host's account.
+func (a *API) LookupTrack(ctx context.Context, host WSUser, trackURI string) (Track, error) {
+	trackID := strings.TrimPrefix(trackURI, "spotify:track:")
+	if trackID == trackURI || trackID == "" {
+		return Track{}, fmt.Errorf("invalid track URI %s", trackURI)
+	}
+	client, err := a.hostSpotifyClient(ctx, host)
+	if err != nil {
+		return Track{}, err
+	}
+	fullTrack, err := client.GetTrack(ctx, spotify.ID(trackID))
+	if err != nil {
+		return Track{}, err
+	}
+	return trackFromSpotify(fullTrack), nil
+}
+
+// searchTracks looks up tracks matching query using the host's Spotify account.
+func (a *API) searchTracks(ctx context.Context, host WSUser, query string, limit int) ([]Track, error) {
+	client, err := a.hostSpotifyClient(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	results, err := client.Search(ctx, query, spotify.SearchTypeTrack, spotify.Limit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := []Track{}
+	if results.Tracks == nil {
+		return tracks, nil
+	}
+	for i := range results.Tracks.Tracks {
+		tracks = append(tracks, trackFromSpotify(&results.Tracks.Tracks[i]))
+	}
+	return tracks, nil
+}
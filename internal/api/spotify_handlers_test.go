@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+const fakeSearchResponse = `{"tracks": {"items": [{
+	"id": "4uLU6hMCjMI75M1A2tKUQC",
+	"uri": "spotify:track:4uLU6hMCjMI75M1A2tKUQC",
+	"name": "Never Gonna Give You Up",
+	"duration_ms": 213573,
+	"artists": [{"name": "Rick Astley"}],
+	"album": {"name": "Whenever You Need Somebody", "images": [
+		{"url": "https://i.scdn.co/image/large", "width": 640, "height": 640},
+		{"url": "https://i.scdn.co/image/small", "width": 64, "height": 64}
+	]}
+}], "total": 1}}`
+
+func TestSearchHandler(t *testing.T) {
+	var spotifyStatus int
+	var spotifyQuery url.Values
+	spotify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/search" {
+			http.NotFound(w, r)
+			return
+		}
+		spotifyQuery = r.URL.Query()
+		if spotifyStatus != http.StatusOK {
+			w.WriteHeader(spotifyStatus)
+			w.Write([]byte(`{"error": {"status": 500, "message": "boom"}}`))
+			return
+		}
+		w.Write([]byte(fakeSearchResponse))
+	}))
+	defer spotify.Close()
+
+	a, srv := newTestAPI(t, NewRealClock())
+	a.SpotifyBaseURL = spotify.URL + "/"
+	host := testHost("host")
+	if _, err := a.WSServer.addRoom("party", host, RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Tokens.Save(host.UserID, &oauth2.Token{AccessToken: "host-token"}); err != nil {
+		t.Fatal(err)
+	}
+	_, connectionID := joinTestRoom(t, a, srv, "party", host.UserName)
+
+	search := func(connectionID, limit string) *httptest.ResponseRecorder {
+		query := url.Values{"roomName": {"party"}, "connectionID": {connectionID}, "q": {"rick astley"}}
+		if limit != "" {
+			query.Set("limit", limit)
+		}
+		rec := httptest.NewRecorder()
+		a.SearchHandler(rec, httptest.NewRequest(http.MethodGet, "/search?"+query.Encode(), nil))
+		return rec
+	}
+
+	t.Run("maps tracks", func(t *testing.T) {
+		spotifyStatus = http.StatusOK
+		rec := search(connectionID, "5")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+		}
+		if got := spotifyQuery.Get("limit"); got != "5" {
+			t.Errorf("spotify limit = %q, want 5", got)
+		}
+		if got := spotifyQuery.Get("q"); got != "rick astley" {
+			t.Errorf("spotify query = %q", got)
+		}
+		var response SearchResponse
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		want := []Track{{
+			ID:         "4uLU6hMCjMI75M1A2tKUQC",
+			URI:        "spotify:track:4uLU6hMCjMI75M1A2tKUQC",
+			Title:      "Never Gonna Give You Up",
+			Artists:    []string{"Rick Astley"},
+			Album:      "Whenever You Need Somebody",
+			DurationMs: 213573,
+			ImageURL:   "https://i.scdn.co/image/large",
+		}}
+		if !reflect.DeepEqual(response.Tracks, want) {
+			t.Errorf("tracks = %+v, want %+v", response.Tracks, want)
+		}
+	})
+
+	t.Run("rejects bad limits", func(t *testing.T) {
+		for _, limit := range []string{"0", "51", "ten"} {
+			if rec := search(connectionID, limit); rec.Code != http.StatusBadRequest {
+				t.Errorf("limit %s: status = %d, want 400", limit, rec.Code)
+			}
+		}
+	})
+
+	t.Run("rejects connections outside the room", func(t *testing.T) {
+		spotifyStatus = http.StatusOK
+		if rec := search("not-a-connection", ""); rec.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want 403", rec.Code)
+		}
+	})
+
+	t.Run("reports spotify failures", func(t *testing.T) {
+		spotifyStatus = http.StatusInternalServerError
+		if rec := search(connectionID, ""); rec.Code != http.StatusBadGateway {
+			t.Errorf("status = %d, want 502", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store defines the key/value backend used to persist server state.
+// It is satisfied by *redis_client.Redis and by the in-memory MemoryStore.
+type Store interface {
+	Get(key string) (string, bool, error)
+	Set(key, value string, ttl time.Duration) error
+	// Take returns and deletes the value in one step, so it can only be consumed once.
+	Take(key string) (string, bool, error)
+	Delete(key string) error
+	Keys(prefix string) ([]string, error)
+	// Claim sets key to owner for ttl unless another owner holds it, and reports whether
+	// owner holds it now. Claiming a key again renews the claim.
+	Claim(key, owner string, ttl time.Duration) (bool, error)
+	// Incr atomically adds one to the integer at key, starting from zero, and returns the result.
+	// An integer below floor is raised to floor first.
+	Incr(key string, floor int64) (int64, error)
+	// CompareAndSet sets key to value only if it still holds old, and reports whether it did.
+	// An empty old means key must not exist; an empty value deletes it.
+	CompareAndSet(key, old, value string) (bool, error)
+}
+
+type memoryItem struct {
+	value     string
+	expiresAt time.Time
+}
+
+// MemoryStore is a process-local Store, used when Redis is unavailable and in tests.
+type MemoryStore struct {
+	items map[string]memoryItem
+	mutex *sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		items: make(map[string]memoryItem),
+		mutex: &sync.Mutex{},
+	}
+}
+
+// Ping lets MemoryStore stand in for Redis in the health check.
+func (m *MemoryStore) Ping() (string, error) {
+	return "PONG", nil
+}
+
+func (m *MemoryStore) Get(key string) (string, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.get(key)
+}
+
+func (m *MemoryStore) Take(key string) (string, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value, exists, err := m.get(key)
+	delete(m.items, key)
+	return value, exists, err
+}
+
+// get must be called with the mutex held.
+func (m *MemoryStore) get(key string) (string, bool, error) {
+	item, exists := m.items[key]
+	if !exists {
+		return "", false, nil
+	}
+	if !item.expiresAt.IsZero() && time.Now().After(item.expiresAt) {
+		delete(m.items, key)
+		return "", false, nil
+	}
+	return item.value, true, nil
+}
+
+func (m *MemoryStore) Set(key, value string, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	item := memoryItem{value: value}
+	if ttl > 0 {
+		item.expiresAt = time.Now().Add(ttl)
+	}
+	m.items[key] = item
+	return nil
+}
+
+func (m *MemoryStore) Delete(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.items, key)
+	return nil
+}
+
+func (m *MemoryStore) Claim(key, owner string, ttl time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	holder, exists, _ := m.get(key)
+	if exists && holder != owner {
+		return false, nil
+	}
+	m.items[key] = memoryItem{value: owner, expiresAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (m *MemoryStore) Incr(key string, floor int64) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value, exists, _ := m.get(key)
+	var n int64
+	if exists {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		n = parsed
+	}
+	if n < floor {
+		n = floor
+	}
+	n++
+	item := m.items[key]
+	item.value = strconv.FormatInt(n, 10)
+	m.items[key] = item
+	return n, nil
+}
+
+func (m *MemoryStore) CompareAndSet(key, old, value string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	current, _, _ := m.get(key)
+	if current != old {
+		return false, nil
+	}
+	if value == "" {
+		delete(m.items, key)
+	} else {
+		m.items[key] = memoryItem{value: value}
+	}
+	return true, nil
+}
+
+func (m *MemoryStore) Keys(prefix string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	keys := []string{}
+	for key, item := range m.items {
+		if !item.expiresAt.IsZero() && now.After(item.expiresAt) {
+			continue
+		}
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	spotifyTokenKeyPrefix = "spotify-user-token:"
+	// tokenRefreshMargin refreshes tokens a little early so a request never starts with a token about to expire.
+	tokenRefreshMargin = time.Minute
+)
+
+// TokenRefresher exchanges a refresh token for a new access token.
+// It is satisfied by *spotifyauth.Authenticator.
+type TokenRefresher interface {
+	RefreshToken(ctx context.Context, token *oauth2.Token) (*oauth2.Token, error)
+}
+
+// TokenManager keeps each Spotify user's token fresh and persisted, keyed by their Spotify user ID
+// rather than their display name, which isn't unique.
+// It is safe for concurrent use; refreshes for the same user are serialised.
+type TokenManager struct {
+	refresher TokenRefresher
+	store     Store
+	tokens    map[string]*oauth2.Token
+	userLocks map[string]*userLock
+	mutex     *sync.Mutex
+}
+
+// userLock serialises refreshes for one user. It is dropped once nobody holds or waits for it.
+type userLock struct {
+	sync.Mutex
+	users int
+}
+
+func NewTokenManager(refresher TokenRefresher, store Store) *TokenManager {
+	return &TokenManager{
+		refresher: refresher,
+		store:     store,
+		tokens:    make(map[string]*oauth2.Token),
+		userLocks: make(map[string]*userLock),
+		mutex:     &sync.Mutex{},
+	}
+}
+
+func spotifyTokenKey(userID string) string {
+	return spotifyTokenKeyPrefix + userID
+}
+
+// Save records the token obtained for userID at login.
+func (tm *TokenManager) Save(userID string, token *oauth2.Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	if err := tm.store.Set(spotifyTokenKey(userID), string(data), 0); err != nil {
+		return err
+	}
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	tm.tokens[userID] = token
+	return nil
+}
+
+// Has reports whether a token is known for userID.
+func (tm *TokenManager) Has(userID string) bool {
+	token, err := tm.load(userID)
+	return err == nil && token != nil
+}
+
+// Token returns a valid access token for userID, refreshing and persisting it first if it is about to expire.
+func (tm *TokenManager) Token(ctx context.Context, userID string) (*oauth2.Token, error) {
+	defer tm.lockUser(userID)()
+
+	token, err := tm.load(userID)
+	if err == nil && token != nil && !tokenFresh(token) {
+		// Another instance may have refreshed it already.
+		token, err = tm.loadStored(userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, fmt.Errorf("user %s hasn't connected a Spotify account", userID)
+	}
+	if tokenFresh(token) {
+		return token, nil
+	}
+	if token.RefreshToken == "" {
+		return nil, fmt.Errorf("spotify token for %s expired and can't be refreshed", userID)
+	}
+
+	// Dropping the access token forces the oauth2 token source to refresh.
+	refreshed, err := tm.refresher.RefreshToken(ctx, &oauth2.Token{RefreshToken: token.RefreshToken})
+	if err != nil {
+		return nil, fmt.Errorf("could not refresh spotify token for %s: %v", userID, err)
+	}
+	if refreshed.RefreshToken == "" {
+		// Spotify doesn't always rotate the refresh token.
+		refreshed.RefreshToken = token.RefreshToken
+	}
+	if err := tm.Save(userID, refreshed); err != nil {
+		return nil, err
+	}
+	return refreshed, nil
+}
+
+// tokenFresh reports whether token can be used without refreshing it first.
+func tokenFresh(token *oauth2.Token) bool {
+	return token.Expiry.IsZero() || time.Until(token.Expiry) > tokenRefreshMargin
+}
+
+// lockUser takes userID's refresh lock and returns the function that releases it.
+func (tm *TokenManager) lockUser(userID string) func() {
+	tm.mutex.Lock()
+	lock, exists := tm.userLocks[userID]
+	if !exists {
+		lock = &userLock{}
+		tm.userLocks[userID] = lock
+	}
+	lock.users++
+	tm.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		tm.mutex.Lock()
+		defer tm.mutex.Unlock()
+		lock.users--
+		if lock.users == 0 {
+			delete(tm.userLocks, userID)
+		}
+	}
+}
+
+// load returns the cached token, falling back to the store so tokens saved by
+// another instance or before a restart are found.
+func (tm *TokenManager) load(userID string) (*oauth2.Token, error) {
+	tm.mutex.Lock()
+	token, exists := tm.tokens[userID]
+	tm.mutex.Unlock()
+	if exists {
+		return token, nil
+	}
+	return tm.loadStored(userID)
+}
+
+// loadStored reads userID's token from the store and caches it.
+func (tm *TokenManager) loadStored(userID string) (*oauth2.Token, error) {
+	data, exists, err := tm.store.Get(spotifyTokenKey(userID))
+	if err != nil || !exists {
+		return nil, err
+	}
+	token := &oauth2.Token{}
+	if err := json.Unmarshal([]byte(data), token); err != nil {
+		return nil, err
+	}
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	tm.tokens[userID] = token
+	return token, nil
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// countingRefresher hands out a new access token on every refresh and counts them.
+type countingRefresher struct {
+	mutex     sync.Mutex
+	refreshes int
+}
+
+func (r *countingRefresher) RefreshToken(ctx context.Context, token *oauth2.Token) (*oauth2.Token, error) {
+	// Slow enough for concurrent callers to pile up behind the refresh.
+	time.Sleep(10 * time.Millisecond)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.refreshes++
+	return &oauth2.Token{AccessToken: "refreshed", Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func (r *countingRefresher) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.refreshes
+}
+
+// expiringToken is due for a refresh.
+func expiringToken() *oauth2.Token {
+	return &oauth2.Token{AccessToken: "old", RefreshToken: "refresh", Expiry: time.Now().Add(tokenRefreshMargin / 2)}
+}
+
+func TestConcurrentTokenRefresh(t *testing.T) {
+	refresher := &countingRefresher{}
+	tm := NewTokenManager(refresher, NewMemoryStore())
+	if err := tm.Save("owner", expiringToken()); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := tm.Token(context.Background(), "owner")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if token.AccessToken != "refreshed" || token.RefreshToken != "refresh" {
+				t.Errorf("got access token %q with refresh token %q", token.AccessToken, token.RefreshToken)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := refresher.count(); got != 1 {
+		t.Errorf("token refreshed %d times, want once", got)
+	}
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	if len(tm.userLocks) != 0 {
+		t.Errorf("%d user locks left behind", len(tm.userLocks))
+	}
+}
+
+func TestTokenRefreshedByAnotherInstance(t *testing.T) {
+	refresher, store := &countingRefresher{}, NewMemoryStore()
+	first, second := NewTokenManager(refresher, store), NewTokenManager(refresher, store)
+	if err := first.Save("owner", expiringToken()); err != nil {
+		t.Fatal(err)
+	}
+	// The second instance caches the token before the first refreshes it.
+	if !second.Has("owner") {
+		t.Fatal("second instance doesn't find the token")
+	}
+
+	if _, err := first.Token(context.Background(), "owner"); err != nil {
+		t.Fatal(err)
+	}
+	token, err := second.Token(context.Background(), "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "refreshed" {
+		t.Errorf("second instance got access token %q, want the refreshed one", token.AccessToken)
+	}
+	if got := refresher.count(); got != 1 {
+		t.Errorf("token refreshed %d times, want once", got)
+	}
+}
@@ -1,5 +1,7 @@
 package api
 
+import "time"
+
 // Response is a generic struct for simple JSON responses.
 type Response struct {
 	Message string `json:"message"`
@@ -20,36 +22,138 @@ type LoginResponse struct {
 	RedirectURL string `json:"redirectURL"`
 }
 
+// GuestTokenRequest asks for a guest token to join rooms under Nickname without signing in.
+type GuestTokenRequest struct {
+	Nickname string `json:"nickname"`
+}
+
+type GuestTokenResponse struct {
+	Token     string    `json:"token"`
+	UserName  string    `json:"userName"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
 // ErrorResponse defines the structure for a generic error response.
 type ErrorResponse struct {
 	Error string `json:"error"`
+	// Code and RetryAfter, in seconds, are set when a suggestion is over the room's limits.
+	Code       string `json:"code,omitempty"`
+	RetryAfter int    `json:"retryAfter,omitempty"`
 }
 
 // CreateRoomRequest defines the structure for the create room request body.
+// The host is the signed-in user making the request.
 type CreateRoomRequest struct {
-	UserName string `json:"userName"`
 	RoomName string `json:"roomName"`
+	// HostHandoff is what happens when the host leaves, one of the HostHandoff policies.
+	// It defaults to promoting the longest-connected guest.
+	HostHandoff string `json:"hostHandoff"`
+	// AccessMode is "open" (the default), "password" or "invite".
+	AccessMode string `json:"accessMode"`
+	Password   string `json:"password"`
+	// RemoveBelowScore drops queued songs whose net score falls below it. It defaults to -2.
+	RemoveBelowScore *int `json:"removeBelowScore"`
+	// SkipThreshold is the share of connected users, between 0 and 1, whose votes skip
+	// the current song. It defaults to half.
+	SkipThreshold float64 `json:"skipThreshold"`
+	// QueueStrategy orders the queue: "votes" (the default), "round_robin", "time_decay" or "fifo_boost".
+	QueueStrategy string `json:"queueStrategy"`
+	// The suggestion limits default to DefaultSuggestionLimits; zero turns a limit off.
+	MaxQueuedPerUser          *int `json:"maxQueuedPerUser"`
+	SuggestionCooldownSeconds *int `json:"suggestionCooldownSeconds"`
+	MaxQueueLength            *int `json:"maxQueueLength"`
 }
 
 type CreateRoomResponse struct {
 	Host     WSUser `json:"host"`
 	RoomName string `json:"roomName"`
+	// InviteToken lets the host into an invite-only room.
+	InviteToken string `json:"inviteToken,omitempty"`
 }
 
+type CreateInviteRequest struct {
+	RoomName     string `json:"roomName"`
+	ConnectionID string `json:"connectionID"`
+	// ExpiresInSeconds defaults to a day.
+	ExpiresInSeconds int `json:"expiresInSeconds"`
+}
+
+type InviteResponse struct {
+	InviteToken string    `json:"inviteToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// SuggestSongRequest identifies a song by its Spotify URI. SongName is kept for
+// clients that only send free text.
 type SuggestSongRequest struct {
 	RoomName     string `json:"roomName"`
+	TrackURI     string `json:"trackUri"`
 	SongName     string `json:"songName"`
 	ConnectionID string `json:"connectionID"`
 }
 
+// VoteRequest votes a song up or down, or with Direction "none" withdraws the vote.
+// Direction defaults to "up".
 type VoteRequest struct {
 	RoomName     string `json:"roomName"`
+	TrackURI     string `json:"trackUri"`
 	SongName     string `json:"songName"`
+	Direction    string `json:"direction"`
 	ConnectionID string `json:"connectionID"`
 }
 
+// SkipSongRequest is also the body of a vote to skip and of removing a song from the queue.
 type SkipSongRequest struct {
 	RoomName     string `json:"roomName"`
+	TrackURI     string `json:"trackUri"`
+	SongName     string `json:"songName"`
+	ConnectionID string `json:"connectionID"`
+}
+
+// PinSongRequest pins a song to play next, or with Pinned unset unpins it.
+type PinSongRequest struct {
+	RoomName     string `json:"roomName"`
+	TrackURI     string `json:"trackUri"`
 	SongName     string `json:"songName"`
+	Pinned       bool   `json:"pinned"`
+	ConnectionID string `json:"connectionID"`
+}
+
+// LockQueueRequest locks the queue to Order, a list of song IDs, for DurationSeconds,
+// which defaults to 15 minutes. An empty Order unlocks it.
+type LockQueueRequest struct {
+	RoomName        string   `json:"roomName"`
+	Order           []string `json:"order"`
+	DurationSeconds int      `json:"durationSeconds"`
+	ConnectionID    string   `json:"connectionID"`
+}
+
+// KickUserRequest removes UserName from the room, and with Ban set keeps them out.
+type KickUserRequest struct {
+	RoomName     string `json:"roomName"`
+	UserName     string `json:"userName"`
+	Reason       string `json:"reason"`
+	Ban          bool   `json:"ban"`
 	ConnectionID string `json:"connectionID"`
 }
+
+type UnbanUserRequest struct {
+	RoomName     string `json:"roomName"`
+	UserName     string `json:"userName"`
+	ConnectionID string `json:"connectionID"`
+}
+
+// Track is the canonical identity of a Spotify track.
+type Track struct {
+	ID         string   `json:"id"`
+	URI        string   `json:"uri"`
+	Title      string   `json:"title"`
+	Artists    []string `json:"artists"`
+	Album      string   `json:"album"`
+	DurationMs int      `json:"durationMs"`
+	ImageURL   string   `json:"imageUrl"`
+}
+
+type SearchResponse struct {
+	Tracks []Track `json:"tracks"`
+}
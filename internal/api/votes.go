@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"log"
+)
+
+// Vote directions. VoteNone withdraws whatever vote the user had cast.
+const (
+	VoteUp   = "up"
+	VoteDown = "down"
+	VoteNone = "none"
+)
+
+// defaultRemoveBelowScore removes a song once three more people have voted it down than up.
+const defaultRemoveBelowScore = -2
+
+const EventSongRemoved = "song_removed"
+
+func validVoteDirection(direction string) bool {
+	return direction == VoteUp || direction == VoteDown || direction == VoteNone
+}
+
+// hasVoted reports whether user is among votes.
+func hasVoted(votes []*WSUser, user WSUser) bool {
+	for _, u := range votes {
+		if u.isEqual(user) {
+			return true
+		}
+	}
+	return false
+}
+
+// vote records user's vote on song, replacing any vote they had cast before,
+// and moves the song to its new place in the queue.
+func (sp *SongPriorityQueue) vote(song *SongConfig, user WSUser, direction string) error {
+	upvoted, downvoted := hasVoted(song.Votes, user), hasVoted(song.Downvotes, user)
+	votes := removeUserFromList(song.Votes, user)
+	downvotes := removeUserFromList(song.Downvotes, user)
+	switch direction {
+	case VoteUp:
+		if upvoted {
+			return fmt.Errorf("user %s has already voted for song %s", user.UserName, song.SongName)
+		}
+		votes = append(votes, &user)
+	case VoteDown:
+		if downvoted {
+			return fmt.Errorf("user %s has already voted against song %s", user.UserName, song.SongName)
+		}
+		downvotes = append(downvotes, &user)
+	case VoteNone:
+		if !upvoted && !downvoted {
+			return fmt.Errorf("user %s hasn't voted on song %s", user.UserName, song.SongName)
+		}
+	default:
+		return fmt.Errorf("invalid vote direction %s", direction)
+	}
+	sp.update(song, votes, downvotes)
+	return nil
+}
+
+// applyVote casts user's vote on a queued song and broadcasts the new order. A song voted
+// below the room's threshold is dropped from the queue.
+// It must be called with the mutex held.
+func (ws *WSServer) applyVote(room *RoomConfig, song *SongConfig, user WSUser, direction string) error {
+	if err := room.SongQueue.vote(song, user, direction); err != nil {
+		return err
+	}
+	// Songs placed by hand stay put whatever the votes say.
+	if song.Score < room.RemoveBelowScore && !ws.manuallyOrdered(room, song) {
+		log.Printf("Removed %s from room %s, its score fell to %d", song.SongName, room.RoomName, song.Score)
+		ws.dropQueuedSong(room, song, user, ReasonDownvoted)
+		return nil
+	}
+	ws.emitQueueEvent(room, EventVoteChanged, user, song)
+	return nil
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVotes(t *testing.T) {
+	type step struct {
+		user, song, direction string
+		wantErr               bool
+		// want is the queue order after the vote.
+		want []string
+	}
+	tests := []struct {
+		name   string
+		pinned []string
+		steps  []step
+	}{
+		{
+			name: "downvotes move a song down",
+			steps: []step{
+				// Equal scores play the older suggestion first.
+				{user: "dave", song: "b", direction: VoteDown, want: []string{"a", "b", "c"}},
+				{user: "erin", song: "b", direction: VoteDown, want: []string{"a", "b", "c"}},
+				{user: "fred", song: "b", direction: VoteDown, want: []string{"a", "c", "b"}},
+			},
+		},
+		{
+			name: "a downvote replaces an upvote",
+			steps: []step{
+				{user: "bob", song: "b", direction: VoteDown, want: []string{"a", "b", "c"}},
+				{user: "bob", song: "b", direction: VoteDown, wantErr: true, want: []string{"a", "b", "c"}},
+				{user: "carol", song: "b", direction: VoteDown, want: []string{"a", "c"}},
+			},
+		},
+		{
+			name: "withdrawing a vote",
+			steps: []step{
+				{user: "bob", song: "b", direction: VoteNone, want: []string{"a", "b", "c"}},
+				{user: "bob", song: "b", direction: VoteNone, wantErr: true, want: []string{"a", "b", "c"}},
+				{user: "dave", song: "c", direction: VoteNone, wantErr: true, want: []string{"a", "b", "c"}},
+			},
+		},
+		{
+			name: "songs voted below the threshold are removed",
+			steps: []step{
+				{user: "dave", song: "c", direction: VoteDown, want: []string{"b", "a", "c"}},
+				{user: "erin", song: "c", direction: VoteDown, want: []string{"b", "a"}},
+			},
+		},
+		{
+			name:   "pinned songs are kept",
+			pinned: []string{"c"},
+			steps: []step{
+				{user: "dave", song: "c", direction: VoteDown, want: []string{"c", "b", "a"}},
+				{user: "erin", song: "c", direction: VoteDown, want: []string{"c", "b", "a"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ws := NewWSServer(NewRoomStore(NewMemoryStore()), NewMemoryBroker(), nil, nil, newFakeClock())
+			removeBelowScore := -1
+			if _, err := ws.addRoom("party", testHost("host"), RoomOptions{RemoveBelowScore: &removeBelowScore}); err != nil {
+				t.Fatal(err)
+			}
+			ws.mutex.Lock()
+			defer ws.mutex.Unlock()
+			err := ws.updateRoom("party", func(room *RoomConfig) error {
+				a := queuedSong("a", "alice", 1, 30*time.Minute)
+				a.Votes = []*WSUser{{UserName: "alice"}}
+				b := queuedSong("b", "bob", 2, 20*time.Minute)
+				b.Votes = []*WSUser{{UserName: "bob"}, {UserName: "carol"}}
+				c := queuedSong("c", "carol", 0, 10*time.Minute)
+				room.SongQueue = SongPriorityQueue{a, b, c}
+				room.PinnedOrder = test.pinned
+				ws.reorderQueue(room)
+				ws.markChanged(room)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, step := range test.steps {
+				err := ws.updateRoom("party", func(room *RoomConfig) error {
+					return ws.applyVote(room, room.SongQueue.find(step.song), WSUser{UserName: step.user}, step.direction)
+				})
+				if (err != nil) != step.wantErr {
+					t.Errorf("%s voting %s on %s returned %v, want error %t", step.user, step.direction, step.song, err, step.wantErr)
+				}
+				queue := ws.roomConfigMap["party"].SongQueue
+				for i := 1; i < len(queue); i++ {
+					if queue.Less(i, (i-1)/2) {
+						t.Errorf("after %s voted %s on %s, song %s sorts before its parent", step.user, step.direction, step.song, queue[i].SongID)
+					}
+				}
+				if got := queueOrder(queue); !reflect.DeepEqual(got, step.want) {
+					t.Errorf("after %s voted %s on %s, queue = %v, want %v", step.user, step.direction, step.song, got, step.want)
+				}
+			}
+		})
+	}
+}
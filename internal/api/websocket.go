@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,46 +18,88 @@ import (
 type WSServer struct {
 	roomConfigMap    map[string]*RoomConfig
 	roomBroadcastMap map[string]chan []byte
-	mutex            *sync.Mutex
+	roomStore        *RoomStore
+	broker           Broker
+	player           Player
+	tracks           TrackResolver
+	clock            Clock
+	instanceID       string
+	conns            map[*websocket.Conn]*clientConn
+	heartbeat        HeartbeatConfig
+	metrics          *Metrics
+	// write is the change to a room in progress, see updateRoom.
+	write *roomWrite
+	mutex *sync.Mutex
 }
 
 type WSUser struct {
 	UserName string `json:"userName"`
 	UserType string `json:"userType"`
 	IsAlive  bool   `json:"isAlive"`
+	IsMuted  bool   `json:"isMuted,omitempty"`
+	// UserID is the identity the user proved when joining, see Identity.
+	// It is kept from clients, who only need the name.
+	UserID string `json:"-"`
+	// Instance is the server instance the user is connected through.
+	Instance string `json:"-"`
+	// ConnectionID is the user's unencrypted connection ID. It is shared between
+	// instances so requests that carry it can be served by any of them.
+	ConnectionID string `json:"-"`
 }
 
+// isEqual compares users by name, which is unique within a room, so a user keeps
+// their votes when they reconnect.
 func (u *WSUser) isEqual(user WSUser) bool {
-	return u.UserName == user.UserName && u.UserType == user.UserType && u.IsAlive == user.IsAlive
+	return u.UserName == user.UserName
 }
 
 func removeUserFromList(users []*WSUser, target WSUser) []*WSUser {
 	result := []*WSUser{}
 	for _, user := range users {
-		if user != &target {
+		// Usernames are unique within a room.
+		if user.UserName != target.UserName {
 			result = append(result, user)
 		}
 	}
 	return result
 }
 
+// BroadcastMessage is a chat message or a full snapshot of a room's state.
+// Snapshots carry the Seq of the last event they include.
 type BroadcastMessage struct {
-	RoomName          string        `json:"roomname"`
-	Sender            WSUser        `json:"sender"`
-	Message           string        `json:"message"`
-	CurrentSongQueue  []*SongConfig `json:"currentSongQueue"`
-	CurrentSong       *SongConfig   `json:"currentSong"`
-	ConnectedUserList []*WSUser     `json:"connectedUserList"`
-	ConnectionID      string        `json:"connectionID"`
+	Type              string         `json:"type"`
+	Seq               uint64         `json:"seq,omitempty"`
+	RoomName          string         `json:"roomname"`
+	Sender            WSUser         `json:"sender"`
+	Message           string         `json:"message"`
+	CurrentSongQueue  []*SongConfig  `json:"currentSongQueue"`
+	CurrentSong       *SongConfig    `json:"currentSong"`
+	ConnectedUserList []*WSUser      `json:"connectedUserList"`
+	ConnectionID      string         `json:"connectionID"`
+	ReconnectToken    string         `json:"reconnectToken,omitempty"`
+	PlaybackError     *PlaybackError `json:"playbackError,omitempty"`
+	SkipTally         *SkipTally     `json:"skipTally,omitempty"`
+	QueueLockedUntil  *time.Time     `json:"queueLockedUntil,omitempty"`
 }
 
 type SongConfig struct {
+	// SongID is the track's Spotify URI, or a normalised name for songs suggested by name only.
+	SongID             string    `json:"songId"`
 	SongName           string    `json:"songName"`
+	Track              Track     `json:"track"`
 	Votes              []*WSUser `json:"votes"`
 	VoteCount          int       `json:"voteCount"`
 	SuggestedBy        WSUser    `json:"suggestedBy"`
 	SuggestedTimestamp time.Time `json:"suggestedTimeStamp"`
 	Index              int       `json:"index"`
+	Downvotes          []*WSUser `json:"downvotes"`
+	// Score is the net vote, upvotes minus downvotes.
+	Score int `json:"score"`
+	// Priority is the song's place in the queue as set by the room's QueueStrategy.
+	Priority float64 `json:"priority"`
+	// Rank places pinned and locked songs ahead of the rest, lowest first. It is zero for other songs.
+	Rank   int  `json:"rank,omitempty"`
+	Pinned bool `json:"pinned,omitempty"`
 }
 
 type SongPriorityQueue []*SongConfig
@@ -66,15 +109,31 @@ func (sp SongPriorityQueue) Len() int {
 }
 
 func (sp SongPriorityQueue) Less(i, j int) bool {
-	if sp[i].VoteCount != sp[j].VoteCount {
-		// Higher the VoteCount, higher the priority
-		return sp[i].VoteCount > sp[j].VoteCount
+	if sp[i].Rank != sp[j].Rank {
+		// Songs placed by hand go first, in the order they were placed
+		if sp[i].Rank == 0 || sp[j].Rank == 0 {
+			return sp[j].Rank == 0
+		}
+		return sp[i].Rank < sp[j].Rank
+	}
+	if sp[i].Priority != sp[j].Priority {
+		// Higher the Priority, higher the priority
+		return sp[i].Priority > sp[j].Priority
 	}
+	if sp[i].Score != sp[j].Score {
+		// Higher the Score, higher the second priority
+		return sp[i].Score > sp[j].Score
+	}
+	return sp.olderFirst(i, j)
+}
+
+// olderFirst breaks ties between songs in favour of the older suggestion.
+func (sp SongPriorityQueue) olderFirst(i, j int) bool {
 	if !sp[i].SuggestedTimestamp.Equal(sp[j].SuggestedTimestamp) {
-		// Lower the timestamp, higher the second priority
+		// Lower the timestamp, higher the third priority
 		return sp[i].SuggestedTimestamp.Before(sp[j].SuggestedTimestamp)
 	}
-	// Lower the ASCII, higher the third priority
+	// Lower the ASCII, higher the fourth priority
 	return sp[i].SongName < sp[j].SongName
 }
 
@@ -100,14 +159,58 @@ func (sp SongPriorityQueue) Swap(i, j int) {
 	sp[j].Index = j
 }
 
-func (sp *SongPriorityQueue) update(currentSong *SongConfig, votes []*WSUser) {
+// songNamePrefix marks song IDs made from a name rather than a Spotify URI.
+const songNamePrefix = "name:"
+
+// songKey returns the identity used to match suggestions, votes and skips.
+// Songs without a Spotify URI fall back to their case-insensitive name.
+func songKey(trackURI, songName string) string {
+	if trackURI != "" {
+		return trackURI
+	}
+	return songNamePrefix + strings.ToLower(strings.TrimSpace(songName))
+}
+
+// matches reports whether songID, as sent by a client, identifies song. Older clients
+// only send a name, which also matches a song queued by its URI.
+func (song *SongConfig) matches(songID string) bool {
+	if song.SongID == songID {
+		return true
+	}
+	name, byName := strings.CutPrefix(songID, songNamePrefix)
+	return byName && strings.ToLower(strings.TrimSpace(song.SongName)) == name
+}
+
+// find returns the queued song songID identifies, preferring an exact match over one by name.
+func (sp SongPriorityQueue) find(songID string) *SongConfig {
+	var byName *SongConfig
+	for _, song := range sp {
+		if song.SongID == songID {
+			return song
+		}
+		if byName == nil && song.matches(songID) {
+			byName = song
+		}
+	}
+	return byName
+}
+
+func (sp *SongPriorityQueue) update(currentSong *SongConfig, votes, downvotes []*WSUser) {
 	currentSong.Votes = votes
 	currentSong.VoteCount = len(votes)
+	currentSong.Downvotes = downvotes
+	currentSong.Score = len(votes) - len(downvotes)
 	heap.Fix(sp, currentSong.Index)
 }
 
 type RoomConfig struct {
-	Host                WSUser
+	Host WSUser
+	// Owner is the user who created the room. They can reclaim it from a promoted host.
+	Owner WSUser
+	// HostHandoff is the policy applied when the host leaves.
+	HostHandoff         string
+	AccessMode          string
+	PasswordHash        string
 	IsHostPresent       bool
 	RoomName            string
 	Clients             map[*websocket.Conn]WSUser
@@ -115,15 +218,253 @@ type RoomConfig struct {
 	ConnectedUserList   []*WSUser
 	SongQueue           SongPriorityQueue
 	CurrentSong         *SongConfig
+	SongStartedAt       time.Time
 	Secret              string
+	Bans                []RoomBan
+	// Seq is the sequence number of the room's latest event.
+	Seq uint64
+	// RemoveBelowScore drops queued songs whose score falls below it.
+	RemoveBelowScore int
+	// QueueStrategy names the QueueStrategy that orders the queue.
+	QueueStrategy    string
+	SuggestionLimits SuggestionLimits
+	// PinnedOrder lists the songs pinned to play next, in order.
+	PinnedOrder []string
+	// LockedOrder is a manual order for the queue that holds until LockedUntil.
+	LockedOrder []string
+	LockedUntil time.Time
+	// StandInRole is the role the current host held before it was handed the room,
+	// given back when the owner reclaims it.
+	StandInRole string
+	// lastSuggestion is when each user last added a song, for the suggestion cooldown.
+	lastSuggestion map[string]time.Time
+	// SkipThreshold is the share of live users whose votes skip the current song.
+	SkipThreshold float64
+	// SkipVotes are the users who voted to skip the current song.
+	SkipVotes      []*WSUser
+	songTimer      Timer
+	ownerlessTimer Timer
+	lockTimer      Timer
+	// members holds the reconnect state of every user in the room, keyed by username.
+	members map[string]*roomMember
+	// Version counts the writes to the stored room.
+	Version uint64
+	// stored is the room's JSON as last read or written by this instance, which a
+	// write only replaces if no other instance changed it since.
+	stored string
 }
 
-func NewWSServer() *WSServer {
-	return &WSServer{
+// NewWSServer creates a server for the persisted rooms in roomStore.
+// player may be nil, in which case CurrentSong is advisory only, and tracks resolves
+// track URIs suggested over the socket. clock drives each room's playback clock.
+func NewWSServer(roomStore *RoomStore, broker Broker, player Player, tracks TrackResolver, clock Clock) *WSServer {
+	instanceID, err := utils.GenerateSecureRandomString(16)
+	if err != nil {
+		log.Fatalf("Could not generate instance ID: %v", err)
+	}
+	ws := &WSServer{
 		roomConfigMap:    make(map[string]*RoomConfig),
 		roomBroadcastMap: make(map[string]chan []byte),
+		roomStore:        roomStore,
+		broker:           broker,
+		player:           player,
+		tracks:           tracks,
+		clock:            clock,
+		instanceID:       instanceID,
+		conns:            make(map[*websocket.Conn]*clientConn),
+		heartbeat:        DefaultHeartbeatConfig(),
+		metrics:          &Metrics{},
 		mutex:            &sync.Mutex{},
 	}
+	ws.announceInstance()
+	ws.restoreRooms()
+	return ws
+}
+
+// announceInstance keeps this instance marked as live for as long as it runs, and renews
+// or takes over the playback of its rooms.
+func (ws *WSServer) announceInstance() {
+	if err := ws.roomStore.MarkInstanceLive(ws.instanceID); err != nil {
+		log.Printf("Could not announce instance %s: %v", ws.instanceID, err)
+	}
+	ws.mutex.Lock()
+	for _, room := range ws.roomConfigMap {
+		ws.checkPlayback(room)
+	}
+	ws.mutex.Unlock()
+	ws.clock.AfterFunc(instanceTTL/3, ws.announceInstance)
+}
+
+func newRoomConfig(roomName string, host WSUser, secret string) *RoomConfig {
+	return &RoomConfig{
+		Host:                host,
+		Owner:               host,
+		HostHandoff:         HostHandoffPromoteLongest,
+		AccessMode:          AccessModeOpen,
+		RemoveBelowScore:    defaultRemoveBelowScore,
+		SkipThreshold:       defaultSkipThreshold,
+		QueueStrategy:       QueueStrategyVotes,
+		SuggestionLimits:    DefaultSuggestionLimits,
+		lastSuggestion:      make(map[string]time.Time),
+		IsHostPresent:       false,
+		RoomName:            roomName,
+		Clients:             make(map[*websocket.Conn]WSUser),
+		ConnectionIDUserMap: make(map[string]*websocket.Conn),
+		SongQueue:           SongPriorityQueue{},
+		CurrentSong:         nil,
+		ConnectedUserList:   []*WSUser{},
+		Secret:              secret,
+		members:             make(map[string]*roomMember),
+	}
+}
+
+// restoreRooms loads the rooms persisted by a previous run so that their queues
+// and current songs survive a restart. Clients have to rejoin after a restore.
+func (ws *WSServer) restoreRooms() {
+	rooms, err := ws.roomStore.LoadRooms()
+	if err != nil {
+		log.Printf("Could not restore rooms: %v", err)
+		return
+	}
+
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	for _, room := range rooms {
+		ws.openRoom(room)
+		ws.checkPlayback(room)
+		ws.scheduleUnlock(room)
+		log.Printf("Restored room %s with %d queued songs", room.RoomName, len(room.SongQueue))
+	}
+}
+
+// openRoom registers a room, subscribes to its updates from every instance and
+// starts its broadcaster. It must be called with the mutex held.
+func (ws *WSServer) openRoom(room *RoomConfig) {
+	broadcastChan := make(chan []byte, 16)
+	ws.roomConfigMap[room.RoomName] = room
+	ws.roomBroadcastMap[room.RoomName] = broadcastChan
+
+	updates, unsubscribe := ws.broker.Subscribe(roomChannel(room.RoomName))
+	go ws.roomBroadcaster(room.RoomName, broadcastChan, unsubscribe)
+	go ws.roomSubscriber(room.RoomName, updates)
+}
+
+// closeRoom stops the room's broadcaster and forgets the room locally.
+// It must be called with the mutex held.
+func (ws *WSServer) closeRoom(roomName string) {
+	if room, exists := ws.roomConfigMap[roomName]; exists {
+		ws.stopSongTimer(room)
+		ws.stopGraceTimers(room)
+		ws.stopOwnerlessTimer(room)
+		ws.stopLockTimer(room)
+	}
+	close(ws.roomBroadcastMap[roomName])
+	delete(ws.roomBroadcastMap, roomName)
+	delete(ws.roomConfigMap, roomName)
+}
+
+// getRoom returns the room, loading it from the store if it was created on another instance.
+// It must be called with the mutex held.
+func (ws *WSServer) getRoom(roomName string) (*RoomConfig, bool) {
+	if room, exists := ws.roomConfigMap[roomName]; exists {
+		return room, true
+	}
+	room, err := ws.roomStore.LoadRoom(roomName)
+	if err != nil {
+		log.Printf("Could not load room %s: %v", roomName, err)
+		return nil, false
+	}
+	if room == nil {
+		return nil, false
+	}
+	ws.openRoom(room)
+	return room, true
+}
+
+// refreshRoom reloads the shared room state, which another instance may have changed.
+// It also drops any change made locally that wasn't stored.
+// Local connections are kept and pick up role changes made elsewhere; if the room was
+// deleted elsewhere, its local clients are disconnected.
+// It must be called with the mutex held.
+func (ws *WSServer) refreshRoom(roomName string) {
+	room, exists := ws.roomConfigMap[roomName]
+	if !exists {
+		return
+	}
+	stored, err := ws.roomStore.LoadRoom(roomName)
+	if err != nil {
+		log.Printf("Could not refresh room %s: %v", roomName, err)
+		return
+	}
+	if stored == nil {
+		log.Printf("Room %s was deleted on another instance", roomName)
+		disconnectClients(room)
+		ws.closeRoom(roomName)
+		return
+	}
+	room.Host = stored.Host
+	room.Owner = stored.Owner
+	room.HostHandoff = stored.HostHandoff
+	room.AccessMode = stored.AccessMode
+	room.PasswordHash = stored.PasswordHash
+	room.IsHostPresent = stored.IsHostPresent
+	room.StandInRole = stored.StandInRole
+	room.ConnectedUserList = stored.ConnectedUserList
+	room.Bans = stored.Bans
+	// Users kicked through another instance are disconnected here too.
+	kicked := make(map[string]string)
+	for conn, user := range room.Clients {
+		listed := listedUser(room, user.UserName)
+		if ban := findBan(room, user.UserName, user.UserID); ban != nil {
+			kicked[user.UserName] = ban.Reason
+		} else if listed == nil {
+			kicked[user.UserName] = ""
+		} else {
+			user.UserType = listed.UserType
+			user.IsMuted = listed.IsMuted
+			room.Clients[conn] = user
+		}
+	}
+	for userName, reason := range kicked {
+		if reason == "" {
+			reason = "removed from the room"
+		}
+		log.Printf("User %s was removed from room %s on another instance", userName, roomName)
+		ws.closeUserConns(room, userName, reason)
+		if member, exists := room.members[userName]; exists && member.graceTimer != nil {
+			member.graceTimer.Stop()
+		}
+		delete(room.members, userName)
+	}
+	room.SongQueue = stored.SongQueue
+	songChanged := stored.CurrentSong == nil || room.CurrentSong == nil || !isCurrentSong(room, stored.CurrentSong.SongID, stored.SongStartedAt)
+	room.CurrentSong = stored.CurrentSong
+	room.SongStartedAt = stored.SongStartedAt
+	if songChanged {
+		// The song changed elsewhere; if this instance runs the playback clock, it times the new song.
+		ws.stopSongTimer(room)
+		ws.checkPlayback(room)
+	}
+	room.Seq = stored.Seq
+	room.RemoveBelowScore = stored.RemoveBelowScore
+	room.SkipThreshold = stored.SkipThreshold
+	room.SkipVotes = stored.SkipVotes
+	room.QueueStrategy = stored.QueueStrategy
+	room.SuggestionLimits = stored.SuggestionLimits
+	room.lastSuggestion = stored.lastSuggestion
+	room.PinnedOrder = stored.PinnedOrder
+	room.LockedOrder = stored.LockedOrder
+	room.LockedUntil = stored.LockedUntil
+	room.Version = stored.Version
+	room.stored = stored.stored
+}
+
+// persistRoom must be called with the mutex held.
+// Persistence failures are logged rather than returned so a Redis outage doesn't stop the party.
+func (ws *WSServer) persistRoom(room *RoomConfig) {
+	if err := ws.roomStore.SaveRoom(room); err != nil {
+		log.Printf("Could not persist room %s: %v", room.RoomName, err)
+	}
 }
 
 var upgrader = websocket.Upgrader{
@@ -135,101 +476,232 @@ var upgrader = websocket.Upgrader{
 func (ws *WSServer) isRoomPresent(roomName string) bool {
 	ws.mutex.Lock()
 	defer ws.mutex.Unlock()
-	_, exists := ws.roomConfigMap[roomName]
+	_, exists := ws.getRoom(roomName)
 	return exists
 }
 
-func (ws *WSServer) addRoom(roomName string, host WSUser) error {
+// addRoom creates a room. For invite-only rooms it returns the invite the host joins with.
+func (ws *WSServer) addRoom(roomName string, host WSUser, options RoomOptions) (string, error) {
+	if err := options.validate(); err != nil {
+		return "", err
+	}
+	var passwordHash string
+	if options.AccessMode == AccessModePassword {
+		hash, err := hashRoomPassword(options.Password)
+		if err != nil {
+			return "", err
+		}
+		passwordHash = hash
+	}
+
 	ws.mutex.Lock()
 	defer ws.mutex.Unlock()
-	if _, exists := ws.roomConfigMap[roomName]; exists {
-		return fmt.Errorf("room %s already present", roomName)
+	if _, exists := ws.getRoom(roomName); exists {
+		return "", fmt.Errorf("room %s already present", roomName)
 	}
 	secret, err := utils.GenerateSecureRandomString(32)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	ws.roomConfigMap[roomName] = &RoomConfig{
-		Host:                host,
-		IsHostPresent:       false,
-		RoomName:            roomName,
-		Clients:             make(map[*websocket.Conn]WSUser),
-		ConnectionIDUserMap: make(map[string]*websocket.Conn),
-		SongQueue:           SongPriorityQueue{},
-		CurrentSong:         nil,
-		ConnectedUserList:   []*WSUser{},
-		Secret:              secret,
+	room := newRoomConfig(roomName, host, secret)
+	room.HostHandoff = options.HostHandoff
+	room.AccessMode = options.AccessMode
+	room.RemoveBelowScore = *options.RemoveBelowScore
+	room.SkipThreshold = options.SkipThreshold
+	room.QueueStrategy = options.QueueStrategy
+	room.SuggestionLimits = *options.SuggestionLimits
+	room.PasswordHash = passwordHash
+	heap.Init(&room.SongQueue)
+
+	var invite string
+	if room.AccessMode == AccessModeInvite {
+		invite, _, err = ws.issueInvite(room, defaultInviteTTL)
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := ws.roomStore.SaveRoom(room); err != nil {
+		return "", fmt.Errorf("could not save room %s: %v", roomName, err)
 	}
-	heap.Init(&ws.roomConfigMap[roomName].SongQueue)
-	ws.roomBroadcastMap[roomName] = make(chan []byte, 16)
-	go ws.roomBroadcaster(roomName)
-	return nil
+	ws.openRoom(room)
+	return invite, nil
+}
+
+// joinedUser is what a client learns when it joins a room. ReconnectToken lets it
+// resume the same identity if its connection drops.
+type joinedUser struct {
+	User           WSUser
+	ConnectionID   string
+	ReconnectToken string
+}
+
+// joinRequest is what a client presents when it joins a room.
+type joinRequest struct {
+	RoomName string
+	Identity Identity
+	// ReconnectToken resumes a disconnected member.
+	ReconnectToken string
+	// Password and Invite are checked against the room's access mode.
+	Password string
+	Invite   string
 }
 
 // joinUser atomically checks conditions and adds a user to a room.
 // It prevents race conditions by performing all checks and modifications within a single lock.
-func (ws *WSServer) joinUser(roomName string, userName string, conn *websocket.Conn) (WSUser, string, error) {
+// A user holding the reconnect token of a disconnected member resumes that member.
+func (ws *WSServer) joinUser(req joinRequest, conn *websocket.Conn) (*joinedUser, error) {
+	// Checked up front so a slow password hash doesn't hold the mutex. Members
+	// resuming their place were admitted when they first joined.
+	accessErr := ws.checkAccess(req.RoomName, req.Password, req.Invite)
+
 	ws.mutex.Lock()
 	defer ws.mutex.Unlock()
 
-	room, roomExists := ws.roomConfigMap[roomName]
+	roomName, userName, userID, reconnectToken := req.RoomName, req.Identity.UserName, req.Identity.UserID, req.ReconnectToken
+
+	room, roomExists := ws.getRoom(roomName)
 	if !roomExists {
-		return WSUser{}, "", fmt.Errorf("room '%s' not found", roomName)
+		return nil, fmt.Errorf("room '%s' not found", roomName)
+	}
+	connID, encryptedConnID, newReconnectToken, err := newConnectionCredentials(room)
+	if err != nil {
+		return nil, err
 	}
 
-	// Check for duplicate username
-	for _, existingUser := range room.Clients {
-		if existingUser.UserName == userName {
-			return WSUser{}, "", fmt.Errorf("user '%s' is already present in the room", userName)
+	var user WSUser
+	var resumed *roomMember
+	err = ws.updateRoom(roomName, func(current *RoomConfig) error {
+		room, resumed = current, nil
+		if findBan(room, userName, userID) != nil {
+			return fmt.Errorf("you are banned from room '%s'", roomName)
 		}
-	}
 
-	var userType string
-	if userName == room.Host.UserName {
-		if room.IsHostPresent {
-			return WSUser{}, "", fmt.Errorf("host already present in room '%s'", roomName)
+		// Check for duplicate username, including members who may still reconnect.
+		if member, exists := room.members[userName]; exists {
+			listed := listedUser(room, userName)
+			if listed != nil && listed.UserID != userID {
+				return fmt.Errorf("the name '%s' is taken in room '%s'", userName, roomName)
+			}
+			if !member.isDisconnected() {
+				return fmt.Errorf("user '%s' is already present in the room", userName)
+			}
+			if !reconnectTokenMatches(member, reconnectToken) {
+				return fmt.Errorf("user '%s' is reconnecting to the room", userName)
+			}
+			if listed == nil {
+				return fmt.Errorf("user '%s' is no longer in the room", userName)
+			}
+			listed.IsAlive = true
+			listed.ConnectionID = connID
+			user = *listed
+			resumed = member
+			log.Printf("User %s reconnected to room %s", userName, roomName)
+			ws.emit(room, RoomEvent{Event: EventUserReconnected, Sender: user, User: &user})
+			return nil
 		}
-		userType = "host"
-	} else {
-		if !room.IsHostPresent {
-			return WSUser{}, "", fmt.Errorf("host is not yet present in room '%s', please wait", roomName)
+		if listed := listedUser(room, userName); listed != nil {
+			// Connected through another instance, which holds their reconnect state.
+			if listed.UserID != userID {
+				return fmt.Errorf("the name '%s' is taken in room '%s'", userName, roomName)
+			}
+			return fmt.Errorf("user '%s' is already present in the room", userName)
+		}
+		// The owner always gets back into their own room, even once their invite has expired.
+		if accessErr != nil && userID != room.Owner.UserID {
+			return accessErr
 		}
-		userType = "guest"
-	}
 
-	connID, err := utils.GenerateSecureRandomString(32)
-	if err != nil {
-		return WSUser{}, "", err
-	}
-	encryptedConnID, err := utils.Encrypt(connID, room.Secret)
+		var userType string
+		switch {
+		case userID == room.Owner.UserID:
+			if room.IsHostPresent && room.Host.UserID == userID {
+				return fmt.Errorf("host already present in room '%s'", roomName)
+			}
+			userType = UserTypeHost
+		case userID == room.Host.UserID && !room.IsHostPresent:
+			// A promoted host coming back, e.g. after a restart.
+			userType = UserTypeHost
+		case strings.EqualFold(userName, room.Owner.UserName) || strings.EqualFold(userName, room.Host.UserName):
+			// Nobody gets to pass themselves off as the host, even while the host is away.
+			return fmt.Errorf("the name '%s' belongs to the host of room '%s'", userName, roomName)
+		case !room.IsHostPresent:
+			return fmt.Errorf("host is not yet present in room '%s', please wait", roomName)
+		default:
+			userType = UserTypeGuest
+		}
+
+		user = WSUser{
+			UserName:     userName,
+			UserType:     userType,
+			IsAlive:      true,
+			UserID:       userID,
+			Instance:     ws.instanceID,
+			ConnectionID: connID,
+		}
+		if user.UserType == UserTypeHost {
+			ws.takeHost(room, user)
+		}
+		listed := user
+		room.ConnectedUserList = append(room.ConnectedUserList, &listed)
 
+		// The joiner gets its connection ID in a snapshot of its own, see JoinRoomHandler.
+		ws.emit(room, RoomEvent{Event: EventUserJoined, Sender: user, User: &user})
+		return nil
+	})
 	if err != nil {
-		return WSUser{}, "", err
+		return nil, err
 	}
 
-	user := WSUser{
-		UserName: userName,
-		UserType: userType,
-		IsAlive:  true,
+	if resumed != nil {
+		resumed.graceTimer.Stop()
+		resumed.graceTimer = nil
 	}
+	ws.attachUser(room, user, conn, connID, newReconnectToken)
+	return &joinedUser{User: user, ConnectionID: encryptedConnID, ReconnectToken: newReconnectToken}, nil
+}
 
-	if user.UserType == "host" {
-		room.IsHostPresent = true
+// newConnectionCredentials generates a connection ID, encrypted for the client with the
+// room's secret, and a reconnect token.
+func newConnectionCredentials(room *RoomConfig) (connID, encryptedConnID, reconnectToken string, err error) {
+	connID, err = utils.GenerateSecureRandomString(32)
+	if err != nil {
+		return "", "", "", err
+	}
+	encryptedConnID, err = utils.Encrypt(connID, room.Secret)
+	if err != nil {
+		return "", "", "", err
+	}
+	reconnectToken, err = utils.GenerateSecureRandomString(32)
+	if err != nil {
+		return "", "", "", err
 	}
+	return connID, encryptedConnID, reconnectToken, nil
+}
 
+// attachUser binds user to conn under connID, and makes reconnectToken the one that
+// resumes them.
+// It must be called with the mutex held.
+func (ws *WSServer) attachUser(room *RoomConfig, user WSUser, conn *websocket.Conn, connID, reconnectToken string) {
 	room.Clients[conn] = user
 	room.ConnectionIDUserMap[connID] = conn
-	room.ConnectedUserList = append(room.ConnectedUserList, &user)
-
-	ws.broadcastUpdate(roomName, encryptedConnID, user)
-	return user, encryptedConnID, nil
+	ws.trackConn(conn)
+	if member, exists := room.members[user.UserName]; exists {
+		member.reconnectToken = reconnectToken
+	} else {
+		room.members[user.UserName] = &roomMember{reconnectToken: reconnectToken}
+	}
 }
 
-func (ws *WSServer) removeUser(roomName, connectionID string, conn *websocket.Conn) error {
+// removeUser detaches a closed connection from its room. A user who left on purpose is
+// removed straight away; anyone else is held for reconnectGracePeriod.
+func (ws *WSServer) removeUser(roomName, connectionID string, conn *websocket.Conn, leaving bool) error {
 	ws.mutex.Lock()
 	defer ws.mutex.Unlock()
 
+	// The connection is gone either way, even if its room already closed.
+	ws.untrackConn(conn)
+
 	room, roomExists := ws.roomConfigMap[roomName]
 	if !roomExists {
 		log.Printf("Room %s not present", roomName)
@@ -250,228 +722,235 @@ func (ws *WSServer) removeUser(roomName, connectionID string, conn *websocket.Co
 
 	delete(room.Clients, conn)
 	delete(room.ConnectionIDUserMap, decryptedConnID)
-	room.ConnectedUserList = removeUserFromList(room.ConnectedUserList, user)
-	log.Printf("User %s removed from room %s\n", user.UserName, roomName)
 
-	if user.UserType == "host" {
-		log.Printf("Host left room %s. Deleting room.\n", roomName)
-		close(ws.roomBroadcastMap[roomName])
-		delete(ws.roomBroadcastMap, roomName)
-		delete(ws.roomConfigMap, roomName)
-	} else {
-		ws.broadcastUpdate(roomName, connectionID, user)
-	}
-	return nil
+	return ws.updateRoom(roomName, func(room *RoomConfig) error {
+		listed := listedUser(room, user.UserName)
+		if listed == nil {
+			// Kicked, or removed on another instance.
+			return nil
+		}
+		if leaving {
+			ws.dropUser(room, *listed)
+		} else {
+			ws.holdUser(room, *listed)
+		}
+		return nil
+	})
 }
 
-func (ws *WSServer) addSuggestedSong(songName, roomName, connectionID string) error {
-	ws.mutex.Lock()
-	defer ws.mutex.Unlock()
-
-	room, roomExists := ws.roomConfigMap[roomName]
-	if !roomExists {
-		log.Printf("Room %s not present", roomName)
-		return fmt.Errorf("room %s not present", roomName)
+// dropUser removes user from the room for good. If the host leaves, the room is handed off.
+// It must be called with the mutex held.
+func (ws *WSServer) dropUser(room *RoomConfig, user WSUser) {
+	ws.forgetUser(room, user)
+	if user.UserType == UserTypeHost {
+		ws.handOffHost(room, user)
+	} else {
+		user.IsAlive = false
+		ws.emit(room, RoomEvent{Event: EventUserLeft, Sender: user, User: &user})
 	}
+	ws.recountSkipVotes(room, user)
+}
 
-	for _, song := range room.SongQueue {
-		if song.SongName == songName {
-			log.Printf("Song already suggested by %s", song.SuggestedBy.UserName)
-			return fmt.Errorf("song already suggested by %s", song.SuggestedBy.UserName)
-		}
+// forgetUser removes user from the room's member and user lists.
+// It must be called with the mutex held.
+func (ws *WSServer) forgetUser(room *RoomConfig, user WSUser) {
+	if member, exists := room.members[user.UserName]; exists && member.graceTimer != nil {
+		member.graceTimer.Stop()
 	}
+	delete(room.members, user.UserName)
+	room.ConnectedUserList = removeUserFromList(room.ConnectedUserList, user)
+	log.Printf("User %s removed from room %s\n", user.UserName, room.RoomName)
+}
 
+// userForConnection resolves an encrypted connection ID to the user holding it. The
+// user may be connected through any instance, so requests carrying a connection ID
+// don't need to reach the instance holding the connection.
+// It must be called with the mutex held.
+func (ws *WSServer) userForConnection(room *RoomConfig, connectionID string) (WSUser, error) {
 	decryptedConnId, err := utils.Decrypt(connectionID, room.Secret)
-
 	if err != nil {
-		return err
-	}
-
-	conn, exists := room.ConnectionIDUserMap[decryptedConnId]
-	if !exists {
-		log.Printf("Connection with connectionID %s doesn't exist", connectionID)
-		return fmt.Errorf("connection with connection id %s doesn't exist", connectionID)
+		return WSUser{}, err
 	}
-	user := room.Clients[conn]
 
-	if len(room.SongQueue) == 0 && room.CurrentSong == nil {
-		room.CurrentSong = &SongConfig{
-			SongName:           songName,
-			Votes:              []*WSUser{&user},
-			VoteCount:          1,
-			SuggestedBy:        user,
-			SuggestedTimestamp: time.Now(),
+	for _, user := range room.ConnectedUserList {
+		if user.IsAlive && user.ConnectionID != "" && user.ConnectionID == decryptedConnId {
+			return *user, nil
 		}
-		ws.broadcastUpdate(roomName, connectionID, user)
-		return nil
 	}
+	log.Printf("Connection with connectionID %s doesn't exist", connectionID)
+	return WSUser{}, fmt.Errorf("connection with connection id %s doesn't exist", connectionID)
+}
 
-	heap.Push(
-		&room.SongQueue,
-		&SongConfig{
-			SongName:           songName,
-			Votes:              []*WSUser{&user},
-			VoteCount:          1,
-			SuggestedBy:        user,
-			SuggestedTimestamp: time.Now(),
-		},
-	)
-
-	ws.broadcastUpdate(roomName, connectionID, user)
-	return nil
+// spotifyAccount is the user whose Spotify account the room plays and searches with.
+// That is the owner, who signed in to create the room; a host promoted in their absence
+// may be a guest without one.
+func spotifyAccount(room *RoomConfig) WSUser {
+	return room.Owner
 }
 
-func (ws *WSServer) voteForSong(songName, roomName, connectionID string) error {
+// roomAccountForConnection returns the room's Spotify account after checking that
+// connectionID belongs to the room.
+func (ws *WSServer) roomAccountForConnection(roomName, connectionID string) (WSUser, error) {
 	ws.mutex.Lock()
 	defer ws.mutex.Unlock()
 
-	room, roomExists := ws.roomConfigMap[roomName]
+	ws.refreshRoom(roomName)
+	room, roomExists := ws.getRoom(roomName)
 	if !roomExists {
-		log.Printf("Room %s not present", roomName)
-		return fmt.Errorf("room %s not present", roomName)
+		return WSUser{}, fmt.Errorf("room %s not present", roomName)
 	}
+	if _, err := ws.userForConnection(room, connectionID); err != nil {
+		return WSUser{}, err
+	}
+	return spotifyAccount(room), nil
+}
 
-	decryptedConnId, err := utils.Decrypt(connectionID, room.Secret)
+func (ws *WSServer) addSuggestedSong(track Track, roomName, connectionID string) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
 
-	if err != nil {
-		return err
-	}
+	return ws.updateRoom(roomName, func(room *RoomConfig) error {
+		user, err := ws.authorize(room, connectionID, PermissionSuggest)
+		if err != nil {
+			return err
+		}
 
-	conn, exists := room.ConnectionIDUserMap[decryptedConnId]
-	if !exists {
-		log.Printf("Connection with connectionID %s doesn't exist", connectionID)
-		return fmt.Errorf("connection with connection id %s doesn't exist", connectionID)
-	}
-	user := room.Clients[conn]
-
-	for _, song := range room.SongQueue {
-		if song.SongName == songName {
-			for _, u := range song.Votes {
-				if u.isEqual(user) {
-					log.Printf("User %s has already voted for song %s", user.UserName, songName)
-					return fmt.Errorf("user %s has already voted for song %s", user.UserName, songName)
-				}
+		songID := songKey(track.URI, track.Title)
+		if room.CurrentSong != nil && room.CurrentSong.matches(songID) {
+			log.Printf("Song %s is already playing", room.CurrentSong.SongName)
+			return fmt.Errorf("song %s is already playing", room.CurrentSong.SongName)
+		}
+
+		// Suggesting a song that is already queued counts as a vote for it,
+		// so the same track never shows up twice.
+		if song := room.SongQueue.find(songID); song != nil {
+			if hasVoted(song.Votes, user) {
+				log.Printf("Song already suggested by %s", song.SuggestedBy.UserName)
+				return fmt.Errorf("song already suggested by %s", song.SuggestedBy.UserName)
 			}
-			votes := append(song.Votes, &user)
-			ws.roomConfigMap[roomName].SongQueue.update(song, votes)
-			ws.broadcastUpdate(roomName, connectionID, user)
-			log.Printf("User %s cast a vote for the song %s", user.UserName, songName)
+			log.Printf("User %s suggested queued song %s, counted as a vote", user.UserName, song.SongName)
+			return ws.applyVote(room, song, user, VoteUp)
+		}
+		if err := ws.checkSuggestionLimits(room, user); err != nil {
+			log.Printf("Suggestion from %s rejected in room %s: %v", user.UserName, roomName, err)
+			return err
+		}
+		room.lastSuggestion[user.UserName] = ws.clock.Now()
+
+		song := &SongConfig{
+			SongID:             songID,
+			SongName:           track.Title,
+			Track:              track,
+			Votes:              []*WSUser{&user},
+			VoteCount:          1,
+			Downvotes:          []*WSUser{},
+			Score:              1,
+			SuggestedBy:        user,
+			SuggestedTimestamp: ws.clock.Now(),
+		}
+
+		if len(room.SongQueue) == 0 && room.CurrentSong == nil {
+			ws.startSong(room, song)
+			ws.emitNowPlaying(room, user)
 			return nil
 		}
-	}
 
-	log.Printf("Song hasn't been suggested")
-	return fmt.Errorf("song hasn't been suggested")
+		heap.Push(&room.SongQueue, song)
+		ws.emitQueueEvent(room, EventSongAdded, user, song)
+		return nil
+	})
 }
 
-func (ws *WSServer) skipSong(songName, roomName, connectionID string) error {
+// voteForSong casts, changes or withdraws a vote; direction is one of the Vote directions.
+func (ws *WSServer) voteForSong(songID, roomName, connectionID, direction string) error {
 	ws.mutex.Lock()
 	defer ws.mutex.Unlock()
 
-	room, roomExists := ws.roomConfigMap[roomName]
-	if !roomExists {
-		log.Printf("Room %s not present", roomName)
-		return fmt.Errorf("room %s not present", roomName)
-	}
+	return ws.updateRoom(roomName, func(room *RoomConfig) error {
+		user, err := ws.authorize(room, connectionID, PermissionVote)
+		if err != nil {
+			return err
+		}
 
-	decryptedConnId, err := utils.Decrypt(connectionID, room.Secret)
+		song := room.SongQueue.find(songID)
+		if song == nil {
+			log.Printf("Song hasn't been suggested")
+			return fmt.Errorf("song hasn't been suggested")
+		}
+		if err := ws.applyVote(room, song, user, direction); err != nil {
+			log.Printf("Could not record vote: %v", err)
+			return err
+		}
+		log.Printf("User %s voted %s on the song %s", user.UserName, direction, song.SongName)
+		return nil
+	})
+}
 
-	if err != nil {
-		return err
-	}
+func (ws *WSServer) skipSong(songID, roomName, connectionID string) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
 
-	conn, exists := room.ConnectionIDUserMap[decryptedConnId]
-	if !exists {
-		log.Printf("Connection with connectionID %s doesn't exist", connectionID)
-		return fmt.Errorf("connection with connection id %s doesn't exist", connectionID)
-	}
-	user := room.Clients[conn]
-	if user.UserType != "host" {
-		log.Printf("Only a host can skip a song, %s", user.UserType)
-		return fmt.Errorf("only a host can skip a song")
-	}
-	if room.CurrentSong == nil {
-		log.Printf("No song is playing at the moment")
-		return nil
-	}
-	if songName != room.CurrentSong.SongName {
-		log.Printf("Can't skip a song that is not playing")
-		return fmt.Errorf("can't skip a song that is not playing")
-	}
-	room.CurrentSong = nil
-	if len(room.SongQueue) == 0 {
-		log.Printf("no more songs in the queue")
+	return ws.updateRoom(roomName, func(room *RoomConfig) error {
+		user, err := ws.authorize(room, connectionID, PermissionSkip)
+		if err != nil {
+			return err
+		}
+		if room.CurrentSong == nil {
+			log.Printf("No song is playing at the moment")
+			return nil
+		}
+		if !room.CurrentSong.matches(songID) {
+			log.Printf("Can't skip a song that is not playing")
+			return fmt.Errorf("can't skip a song that is not playing")
+		}
+		skipped := room.CurrentSong
+		nextSong := ws.advanceSong(room)
+		if nextSong == nil {
+			log.Printf("no more songs in the queue")
+		} else {
+			log.Printf("Skipped %s, playing %s", skipped.SongName, nextSong.SongName)
+		}
+		ws.emitNowPlaying(room, user)
 		return nil
-	}
-
-	nextSong := heap.Pop(&room.SongQueue).(*SongConfig)
-	room.CurrentSong = nextSong
-	log.Printf("Skipped %s, playing %s", songName, nextSong.SongName)
-	ws.broadcastUpdate(roomName, connectionID, user)
-	return nil
+	})
 }
 
 func (ws *WSServer) handleClientMessages(roomName, connectionID string, conn *websocket.Conn) {
+	// A normal closure means the user left; anything else may be a dropped connection they will resume.
+	leaving := false
 	defer func() {
-		ws.removeUser(roomName, connectionID, conn)
+		ws.removeUser(roomName, connectionID, conn, leaving)
 		conn.Close()
 	}()
 
+	conn.SetPongHandler(func(string) error {
+		ws.touch(conn)
+		return nil
+	})
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
 			log.Printf("Error reading message from client in room %s: %v\n", roomName, err)
+			leaving = websocket.IsCloseError(err, websocket.CloseNormalClosure)
 			break
 		}
+		ws.touch(conn)
 
-		ws.mutex.Lock()
-		room, roomExists := ws.roomConfigMap[roomName]
-		if !roomExists {
-			ws.mutex.Unlock()
-			break
-		}
-		broadCaseMessage := BroadcastMessage{
-			Message:           string(message),
-			RoomName:          roomName,
-			Sender:            room.Clients[conn],
-			CurrentSongQueue:  room.SongQueue,
-			ConnectedUserList: room.ConnectedUserList,
-		}
-		marshalledMessage, err := json.Marshal(broadCaseMessage)
-		if err != nil {
-			log.Printf("Error marshaling message: %v\n", err)
-			ws.mutex.Unlock()
+		var envelope Envelope
+		if err := json.Unmarshal(message, &envelope); err == nil && envelope.Type != "" {
+			ws.handleCommand(roomName, connectionID, conn, envelope)
 			continue
 		}
 
-		if broadcastChan, ok := ws.roomBroadcastMap[roomName]; ok {
-			select {
-			case broadcastChan <- marshalledMessage:
-			default:
-				log.Printf("Warning: broadcast channel for room %s is full. Message from user dropped.", roomName)
-			}
+		// Anything that isn't an envelope is relayed as a chat message, as older clients expect.
+		if err := ws.sendChat(roomName, conn, string(message)); err != nil {
+			log.Printf("Could not relay message in room %s: %v\n", roomName, err)
 		}
-		ws.mutex.Unlock()
 	}
 }
 
-// broadcastUpdate must be called with the mutex held.
-// It constructs the current state and sends it to the room's broadcast channel.
-func (ws *WSServer) broadcastUpdate(roomName, connectionID string, sender WSUser) {
-	room, roomExists := ws.roomConfigMap[roomName]
-	if !roomExists {
-		return
-	}
-
-	broadcastMessage := BroadcastMessage{
-		Sender:            sender,
-		RoomName:          roomName,
-		CurrentSongQueue:  room.SongQueue,
-		CurrentSong:       room.CurrentSong,
-		ConnectedUserList: room.ConnectedUserList,
-		ConnectionID:      connectionID,
-	}
-
+// broadcast must be called with the mutex held.
+func (ws *WSServer) broadcast(roomName string, broadcastMessage interface{}) {
 	marshalledMessage, err := json.Marshal(broadcastMessage)
 	if err != nil {
 		log.Printf("Error marshaling update message: %v\n", err)
@@ -484,22 +963,56 @@ func (ws *WSServer) broadcastUpdate(roomName, connectionID string, sender WSUser
 	select {
 	case ws.roomBroadcastMap[roomName] <- marshalledMessage:
 	default:
+		ws.metrics.roomBacklogDrops.Add(1)
 		log.Printf("Warning: broadcast channel for room %s is full. Update dropped.", roomName)
 	}
 }
 
-func (ws *WSServer) roomBroadcaster(roomName string) {
-	broadcastChan, ok := ws.roomBroadcastMap[roomName]
-	if !ok {
-		return
-	}
+// roomUpdate wraps a broadcast so instances can tell their own updates from remote ones.
+type roomUpdate struct {
+	Origin  string          `json:"origin"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func roomChannel(roomName string) string {
+	return "room-updates:" + roomName
+}
+
+// roomBroadcaster publishes the room's updates to every instance through the broker.
+// It runs until the room's broadcast channel is closed.
+func (ws *WSServer) roomBroadcaster(roomName string, broadcastChan chan []byte, unsubscribe func()) {
+	defer unsubscribe()
 
 	for msg := range broadcastChan {
+		update, err := json.Marshal(roomUpdate{Origin: ws.instanceID, Payload: msg})
+		if err != nil {
+			log.Printf("Error marshaling room update: %v\n", err)
+			continue
+		}
+		if err := ws.broker.Publish(roomChannel(roomName), update); err != nil {
+			log.Printf("Could not publish update for room %s: %v", roomName, err)
+		}
+	}
+}
+
+// roomSubscriber forwards updates published by any instance to the clients connected to this one.
+func (ws *WSServer) roomSubscriber(roomName string, updates <-chan []byte) {
+	for data := range updates {
+		var update roomUpdate
+		if err := json.Unmarshal(data, &update); err != nil {
+			log.Printf("Error unmarshaling room update: %v\n", err)
+			continue
+		}
+
 		ws.mutex.Lock()
+		if update.Origin != ws.instanceID {
+			ws.refreshRoom(roomName)
+		}
 		room, ok := ws.roomConfigMap[roomName]
 		if !ok {
+			// Keep draining until the broker closes the subscription.
 			ws.mutex.Unlock()
-			return // Room was closed.
+			continue
 		}
 
 		// Copy client connections to a slice to avoid holding the lock during I/O.
@@ -510,7 +1023,7 @@ func (ws *WSServer) roomBroadcaster(roomName string) {
 		ws.mutex.Unlock()
 
 		for _, c := range clients {
-			c.WriteMessage(websocket.TextMessage, msg)
+			ws.writeTo(c, update.Payload)
 		}
 	}
 }
@@ -3,6 +3,7 @@ package redis_client
 import (
 	"context"
 	"log"
+	"time"
 
 	"woahtify-backend/utils"
 
@@ -38,3 +39,130 @@ func NewRedis(ctx context.Context) *Redis {
 func (r *Redis) Ping() (string, error) {
 	return r.client.Ping(r.ctx).Result()
 }
+
+// Get returns the value stored at key. The boolean is false when the key does not exist.
+func (r *Redis) Get(key string) (string, bool, error) {
+	value, err := r.client.Get(r.ctx, key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// Set stores value at key. A zero ttl keeps the key until it is deleted.
+func (r *Redis) Set(key, value string, ttl time.Duration) error {
+	return r.client.Set(r.ctx, key, value, ttl).Err()
+}
+
+// Take atomically returns and deletes the value stored at key.
+func (r *Redis) Take(key string) (string, bool, error) {
+	value, err := r.client.GetDel(r.ctx, key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// claimScript sets KEYS[1] to ARGV[1] for ARGV[2] milliseconds unless another owner holds it.
+var claimScript = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if holder and holder ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return 1
+`)
+
+// Claim sets key to owner for ttl unless another owner holds it, and reports whether owner holds it now.
+func (r *Redis) Claim(key, owner string, ttl time.Duration) (bool, error) {
+	claimed, err := claimScript.Run(r.ctx, r.client, []string{key}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return claimed == 1, nil
+}
+
+// compareAndSetScript sets KEYS[1] to ARGV[2] if it holds ARGV[1]. An empty ARGV[1] stands
+// for a missing key, and an empty ARGV[2] deletes the key.
+var compareAndSetScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1]) or ""
+if current ~= ARGV[1] then
+	return 0
+end
+if ARGV[2] == "" then
+	redis.call("DEL", KEYS[1])
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// CompareAndSet sets key to value only if it still holds old, and reports whether it did.
+func (r *Redis) CompareAndSet(key, old, value string) (bool, error) {
+	swapped, err := compareAndSetScript.Run(r.ctx, r.client, []string{key}, old, value).Int()
+	if err != nil {
+		return false, err
+	}
+	return swapped == 1, nil
+}
+
+// incrScript adds one to the integer at KEYS[1], first raising it to ARGV[1] if it is lower.
+var incrScript = redis.NewScript(`
+local n = tonumber(redis.call("GET", KEYS[1]) or "0")
+local floor = tonumber(ARGV[1])
+if n < floor then
+	n = floor
+end
+n = n + 1
+redis.call("SET", KEYS[1], n)
+return n
+`)
+
+// Incr adds one to the integer at key, first raising it to floor if it is lower, and returns the result.
+func (r *Redis) Incr(key string, floor int64) (int64, error) {
+	return incrScript.Run(r.ctx, r.client, []string{key}, floor).Int64()
+}
+
+func (r *Redis) Delete(key string) error {
+	return r.client.Del(r.ctx, key).Err()
+}
+
+// Keys returns every key starting with prefix. It uses SCAN so large keyspaces don't block Redis.
+func (r *Redis) Keys(prefix string) ([]string, error) {
+	var keys []string
+	iter := r.client.Scan(r.ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(r.ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *Redis) Publish(channel string, message []byte) error {
+	return r.client.Publish(r.ctx, channel, message).Err()
+}
+
+// Subscribe streams the payloads published on channel until the returned cancel func is called.
+func (r *Redis) Subscribe(channel string) (<-chan []byte, func()) {
+	pubsub := r.client.Subscribe(r.ctx, channel)
+	messages := make(chan []byte, 16)
+	go func() {
+		defer close(messages)
+		for msg := range pubsub.Channel() {
+			messages <- []byte(msg.Payload)
+		}
+	}()
+	return messages, func() {
+		if err := pubsub.Close(); err != nil {
+			log.Printf("Could not close subscription to %s: %v", channel, err)
+		}
+	}
+}